@@ -1,3 +1,3 @@
 module go-monkey-shakyo
 
-go 1.16
+go 1.18
@@ -28,10 +28,21 @@ type Program struct {
 }
 
 func (p *Program) String() string {
+	return joinStatements(p.Statements)
+}
+
+// 文を並べて書き出す
+// 式文にはセミコロンがつかないので、後ろに文が続くときは区切りのセミコロンを補う
+// (補わないと `a; (b)` が `a(b)` のように別のプログラムとして読めてしまう)
+func joinStatements(statements []Statement) string {
 	var out bytes.Buffer
 
-	for _, s := range p.Statements {
+	for i, s := range statements {
 		out.WriteString(s.String())
+
+		if _, ok := s.(*ExpressionStatement); ok && i < len(statements)-1 {
+			out.WriteString(";")
+		}
 	}
 
 	return out.String()
@@ -226,21 +237,23 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode() {}
 
-func (ie *IfExpression) String() string {
+func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
-func (ie *IfExpression) TokenLiteral() string {
+func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
-	out.WriteString("if")
+	out.WriteString("if (")
 	out.WriteString(ie.Condition.String())
-	out.WriteString(" ")
+	out.WriteString(") { ")
 	out.WriteString(ie.Consequence.String())
+	out.WriteString(" }")
 
 	if ie.Alternative != nil {
-		out.WriteString("else ")
+		out.WriteString(" else { ")
 		out.WriteString(ie.Alternative.String())
+		out.WriteString(" }")
 	}
 
 	return out.String()
@@ -257,20 +270,16 @@ func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
 
+// 囲んでいる `{` `}` は含まない(if式や関数リテラルの側で書き出す)
 func (bs *BlockStatement) String() string {
-	var out bytes.Buffer
-
-	for _, statement := range bs.Statements {
-		out.WriteString(statement.String())
-	}
-
-	return out.String()
+	return joinStatements(bs.Statements)
 }
 
 type FunctionLiteral struct {
 	Token      token.Token // 'fn' トークン
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Generator  bool // fn* で定義したジェネレータ関数
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -290,10 +299,14 @@ func (fl *FunctionLiteral) String() string {
 	}
 
 	out.WriteString(fl.TokenLiteral())
+	if fl.Generator {
+		out.WriteString("*")
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(")")
+	out.WriteString(") { ")
 	out.WriteString(fl.Body.String())
+	out.WriteString(" }")
 
 	return out.String()
 }
@@ -333,7 +346,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) String() string       { return `"` + sl.Value + `"` }
 
 type ArrayLiteral struct {
 	Token    token.Token
@@ -342,7 +355,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode() {}
 
-func (al *ArrayLiteral) TokenLiteral() string { return al.TokenLiteral() }
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
 
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
@@ -374,7 +387,7 @@ type IndexExpression struct {
 }
 
 func (ie *IndexExpression) expressionNode()      {}
-func (ie *IndexExpression) TokenLiteral() string { return ie.TokenLiteral() }
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -387,11 +400,48 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// スライス式
+// a[1:3]     // 1番目から3番目の手前まで
+// a[:2]      // 先頭から(省略したところは nil)
+// a[::-1]    // 3つ目は間隔。負なら後ろから
+type SliceExpression struct {
+	Token token.Token // '[' トークン
+	Left  Expression
+	Start Expression
+	End   Expression
+	Step  Expression
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	if se.Step != nil {
+		out.WriteString(":")
+		out.WriteString(se.Step.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 // ハッシュリテラル
 // {<expression> : <expression>, <expression> : <expression>, ...}
 type HashLiteral struct {
 	Token token.Token // '{' トークン
 	Pairs map[Expression]Expression
+	Keys  []Expression // Pairs のキーをソースに書かれた順に並べたもの
 }
 
 func (hl *HashLiteral) expressionNode() {}
@@ -403,8 +453,8 @@ func (hl *HashLiteral) String() string {
 
 	var pairs []string
 
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -413,3 +463,70 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// 構文エラーのために解析できなかった文
+// 壊れたコードでもエディタなどが部分的なASTを扱えるように、エラー箇所にこのノードを残す
+type BadStatement struct {
+	Token token.Token // 解析に失敗した文の先頭のトークン
+}
+
+func (bs *BadStatement) statementNode()       {}
+func (bs *BadStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BadStatement) String() string       { return "<bad statement>" }
+
+// 構文エラーのために解析できなかった式
+type BadExpression struct {
+	Token token.Token // 解析に失敗した位置のトークン
+}
+
+func (be *BadExpression) expressionNode()      {}
+func (be *BadExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BadExpression) String() string       { return "<bad expression>" }
+
+// モジュールの読み込み
+// import <string>
+type ImportExpression struct {
+	Token token.Token // 'import' トークン
+	Path  string      // 読み込むモジュールのパス
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) String() string {
+	return ie.TokenLiteral() + ` "` + ie.Path + `"`
+}
+
+// モジュールの外から見える束縛を作る
+// export let <identifier> = <expression>;
+type ExportStatement struct {
+	Token     token.Token // 'export' トークン
+	Statement *LetStatement
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+// ジェネレータ関数の中で値を1つ渡して、次の値を求められるまで止まる
+// yield <expression>;
+type YieldStatement struct {
+	Token token.Token // 'yield' トークン
+	Value Expression
+}
+
+func (ys *YieldStatement) statementNode()       {}
+func (ys *YieldStatement) TokenLiteral() string { return ys.Token.Literal }
+func (ys *YieldStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ys.TokenLiteral() + " ")
+
+	if ys.Value != nil {
+		out.WriteString(ys.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
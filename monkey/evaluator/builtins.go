@@ -3,34 +3,145 @@ package evaluator
 import (
 	"fmt"
 	"go-monkey-shakyo/monkey/object"
+	"io"
+	"sort"
+	"strings"
 )
 
+// 分類ごとのファイルで定義した組み込み関数もまとめて登録する
+func init() {
+	for _, category := range []map[string]*object.Builtin{
+		stringBuiltins, collectionBuiltins, hashBuiltins, fileBuiltins, jsonBuiltins, systemBuiltins, concurrencyBuiltins, iteratorBuiltins,
+	} {
+		for name, builtin := range category {
+			builtins[name] = builtin
+		}
+	}
+
+	for name, builtin := range builtins {
+		builtin.Name = name
+	}
+}
+
+// 値を1つずつ、後ろに sep をつけて w に書き出す
+// ほかのゴルーチンの出力と混ざらないように、まとめて1回で書き出す
+func writeValues(name string, env *object.Environment, w io.Writer, sep string, args []object.Object) object.Object {
+	var out strings.Builder
+	for _, arg := range args {
+		out.WriteString(arg.Inspect())
+		out.WriteString(sep)
+	}
+
+	if err := env.Settings().Output(w, out.String()); err != nil {
+		return newError("%s: %s", name, err)
+	}
+
+	return NULL
+}
+
+// 環境で使える name の組み込み関数
+// 環境の登録簿で追加・上書きしたものを先に探し、隠したものは見つからないことにする
+func lookupBuiltin(env *object.Environment, name string) (*object.Builtin, bool) {
+	if builtin, ok := env.Builtins().Get(name); ok {
+		return builtin, true
+	}
+
+	if env.Builtins().IsHidden(name) {
+		return nil, false
+	}
+
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// 環境で使える組み込み関数を名前順に並べたもの
+// 標準の組み込み関数はすべてのインタプリタで共有しているので、書き換えられないようにコピーを返す
+func Builtins(env *object.Environment) []*object.Builtin {
+	var list []*object.Builtin
+	for name, builtin := range builtins {
+		if _, ok := env.Builtins().Get(name); ok || env.Builtins().IsHidden(name) {
+			continue
+		}
+		copied := *builtin
+		list = append(list, &copied)
+	}
+	list = append(list, env.Builtins().Defined()...)
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// Params が宣言された組み込み関数の、引数の数と型を確かめる
+func checkBuiltinArgs(builtin *object.Builtin, args []object.Object) *object.Error {
+	if builtin.Params == nil {
+		return nil
+	}
+
+	min, max := 0, 0
+	for _, p := range builtin.Params {
+		switch {
+		case p.Variadic:
+			max = -1
+		case p.Optional:
+			max++
+		default:
+			min++
+			max++
+		}
+	}
+
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		var want string
+		switch {
+		case max < 0:
+			want = fmt.Sprintf("%d or more", min)
+		case min == max:
+			want = fmt.Sprintf("%d", min)
+		default:
+			want = fmt.Sprintf("%d to %d", min, max)
+		}
+
+		return newError("wrong number of arguments to `%s`. got=%d, want=%s", builtin.Name, len(args), want)
+	}
+
+	for i, arg := range args {
+		p := builtin.Params[len(builtin.Params)-1]
+		if i < len(builtin.Params) {
+			p = builtin.Params[i]
+		}
+
+		if p.Type != "" && arg.Type() != p.Type {
+			return newError("argument `%s` to `%s` must be %s, got %s", p.Name, builtin.Name, p.Type, arg.Type())
+		}
+	}
+
+	return nil
+}
+
+// どの環境でも使える標準の組み込み関数
+// すべてのインタプリタで共有するので、init で作り終えたあとは読むだけにする
+// (インタプリタごとの追加や上書きは、環境の BuiltinRegistry に持つ)
 var builtins = map[string]*object.Builtin{
 	"len": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
-			}
-
+		Doc:    "length of an array, a string or a hash",
+		Params: []object.BuiltinParam{{Name: "x"}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			switch arg := args[0].(type) {
 			case *object.Array:
 				return &object.Integer{Value: int64(len(arg.Elements))}
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
+			case *object.Hash:
+				return &object.Integer{Value: int64(arg.Len())}
 			default:
 				return &object.Error{Message: fmt.Sprintf("argument to `len` not supported, got %s", args[0].Type())}
 			}
 		}},
 	"first": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-			}
-
+		Doc:    "first element of an array, or null if it is empty",
+		Params: []object.BuiltinParam{{Name: "arr", Type: object.ARRAY_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			arr := args[0].(*object.Array)
 			if len(arr.Elements) > 0 {
 				return arr.Elements[0]
@@ -40,15 +151,9 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"last": {
-		func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
-			}
-
+		Doc:    "last element of an array, or null if it is empty",
+		Params: []object.BuiltinParam{{Name: "arr", Type: object.ARRAY_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			arr := args[0].(*object.Array)
 			length := len(arr.Elements)
 			if length > 0 {
@@ -59,15 +164,9 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"rest": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
-			}
-
+		Doc:    "new array without the first element, or null if it is empty",
+		Params: []object.BuiltinParam{{Name: "arr", Type: object.ARRAY_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			arr := args[0].(*object.Array)
 			length := len(arr.Elements)
 
@@ -83,18 +182,20 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"push": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
-			}
-
+		Doc: "new array with a value appended",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "value"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			arr := args[0].(*object.Array)
 			length := len(arr.Elements)
 
+			// push を繰り返して配列を育てるスクリプトも、上限に達したらコピーする前に止める
+			if err := checkSize(env, object.ARRAY_OBJ, length+1, sizeOf(arr)+16); err != nil {
+				return err
+			}
+
 			newElements := make([]object.Object, length+1, length+1)
 			copy(newElements, arr.Elements)
 			newElements[length] = args[1]
@@ -104,12 +205,29 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"puts": {
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
-			}
-
-			return NULL
+		Doc:      "print values, one per line",
+		Requires: object.CapStdout,
+		Params:   []object.BuiltinParam{{Name: "values", Variadic: true}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return writeValues("puts", env, env.Settings().StdoutWriter(), "\n", args)
+		},
+	},
+	// print("a", 1) => a1 と、改行せずに書き出す
+	"print": {
+		Doc:      "print values without a newline",
+		Requires: object.CapStdout,
+		Params:   []object.BuiltinParam{{Name: "values", Variadic: true}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return writeValues("print", env, env.Settings().StdoutWriter(), "", args)
+		},
+	},
+	// puts と同じだが、標準エラー出力に書き出す
+	"eprint": {
+		Doc:      "print values to stderr, one per line",
+		Requires: object.CapStdout,
+		Params:   []object.BuiltinParam{{Name: "values", Variadic: true}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return writeValues("eprint", env, env.Settings().StderrWriter(), "\n", args)
 		},
 	},
 }
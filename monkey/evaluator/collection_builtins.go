@@ -0,0 +1,486 @@
+package evaluator
+
+import (
+	"go-monkey-shakyo/monkey/object"
+	"sort"
+)
+
+// 配列を扱う高階の組み込み関数
+// 引数で受け取った関数は applyFunction で呼び出すので、Monkeyの関数も組み込み関数も渡せる
+var collectionBuiltins = map[string]*object.Builtin{
+	// map([1, 2, 3], fn(x) { x * 2 }) => [2, 4, 6]
+	"map": {
+		Doc: "apply a function to each element of an array",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "fn"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := checkCallable("map", "second", args[1]); err != nil {
+				return err
+			}
+
+			arr, fn := args[0].(*object.Array), args[1]
+
+			elements := make([]object.Object, 0, len(arr.Elements))
+			for _, el := range arr.Elements {
+				result := applyFunction(fn, []object.Object{el}, env)
+				if isError(result) {
+					return result
+				}
+
+				elements = append(elements, result)
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// filter([1, 2, 3], fn(x) { x > 1 }) => [2, 3]
+	"filter": {
+		Doc: "elements of an array for which a function returns true",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "fn"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := checkCallable("filter", "second", args[1]); err != nil {
+				return err
+			}
+
+			arr, fn := args[0].(*object.Array), args[1]
+
+			elements := []object.Object{}
+			for _, el := range arr.Elements {
+				result := applyFunction(fn, []object.Object{el}, env)
+				if isError(result) {
+					return result
+				}
+
+				if isTruthy(result) {
+					elements = append(elements, el)
+				}
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// reduce([1, 2, 3], 0, fn(acc, x) { acc + x }) => 6
+	// reduce(seq(1000000), 0, fn(acc, x) { acc + x }) => 499999500000
+	"reduce": {
+		Doc: "fold an array or iterator into a value with an initial value and a function",
+		Params: []object.BuiltinParam{
+			{Name: "arr"},
+			{Name: "initial"},
+			{Name: "fn"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			it, isIterator := args[0].(object.Iterator)
+			if !isIterator && args[0].Type() != object.ARRAY_OBJ {
+				return newError("first argument to `reduce` must be ARRAY or an iterator, got %s", args[0].Type())
+			}
+
+			if err := checkCallable("reduce", "third", args[2]); err != nil {
+				return err
+			}
+
+			// 反復子なら、値を1つずつ取り出しながら畳み込む(配列を作らない)
+			if isIterator {
+				acc := args[1]
+				for {
+					el, ok := it.Next()
+					if !ok {
+						return acc
+					}
+					if isError(el) {
+						return el
+					}
+
+					acc = applyFunction(args[2], []object.Object{acc, el}, env)
+					if isError(acc) {
+						return acc
+					}
+				}
+			}
+
+			acc := args[1]
+			for _, el := range args[0].(*object.Array).Elements {
+				acc = applyFunction(args[2], []object.Object{acc, el}, env)
+				if isError(acc) {
+					return acc
+				}
+			}
+
+			return acc
+		},
+	},
+	// sort([3, 1, 2]) => [1, 2, 3]
+	// 比較関数を渡すときは、1つ目の引数を前に置くなら true を返す関数にする
+	// sort([3, 1, 2], fn(a, b) { a > b }) => [3, 2, 1]
+	"sort": {
+		Doc: "sorted copy of an array, optionally with a less-than function",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "cmp", Optional: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			elements := copyElements(args[0].(*object.Array))
+
+			if len(args) == 1 {
+				return sortByDefault(elements)
+			}
+
+			if err := checkCallable("sort", "second", args[1]); err != nil {
+				return err
+			}
+
+			// 比較関数でエラーが起きても sort は途中で止められないので、最初のエラーを覚えておく
+			var sortErr object.Object
+			sort.SliceStable(elements, func(i, j int) bool {
+				if sortErr != nil {
+					return false
+				}
+
+				result := applyFunction(args[1], []object.Object{elements[i], elements[j]}, env)
+				if isError(result) {
+					sortErr = result
+					return false
+				}
+
+				return isTruthy(result)
+			})
+
+			if sortErr != nil {
+				return sortErr
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// 配列なら要素を、文字列なら文字を逆順にする
+	"reverse": {
+		Doc:    "reversed copy of an array or a string",
+		Params: []object.BuiltinParam{{Name: "x"}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+			case *object.Array:
+				length := len(arg.Elements)
+				elements := make([]object.Object, length)
+				for i, el := range arg.Elements {
+					elements[length-1-i] = el
+				}
+
+				return &object.Array{Elements: elements}
+			case *object.String:
+				length := len(arg.Value)
+				reversed := make([]byte, length)
+				for i := 0; i < length; i++ {
+					reversed[length-1-i] = arg.Value[i]
+				}
+
+				return &object.String{Value: string(reversed)}
+			default:
+				return newError("argument to `reverse` must be ARRAY or STRING, got %s", args[0].Type())
+			}
+		},
+	},
+	// range(3) => [0, 1, 2]
+	// range(1, 4) => [1, 2, 3]
+	// range(0, 10, 3) => [0, 3, 6, 9]
+	"range": {
+		Doc:    "array of integers from start (default 0) to end with an optional step",
+		Params: rangeParams,
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			start, end, step, err := rangeArgs("range", args)
+			if err != nil {
+				return err
+			}
+
+			// 大きすぎる配列は作り始める前に断る(要素の整数の分も見込んでおく)
+			n := rangeLength(start, end, step)
+			if err := checkSize(env, object.ARRAY_OBJ, n, addSize(sizeOf(&object.Array{}), mulSize(n, 16+sizeOf(&object.Integer{})))); err != nil {
+				return err
+			}
+
+			// end の近くで i += step があふれても止まるように、要素の数だけ繰り返す
+			elements := []object.Object{}
+			for i, k := start, 0; k < n; i, k = i+step, k+1 {
+				elements = append(elements, &object.Integer{Value: i})
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// zip([1, 2], ["a", "b", "c"]) => [[1, "a"], [2, "b"]]
+	// 一番短い配列の長さに揃える
+	"zip": {
+		Doc: "array of tuples of elements at the same position in arrays",
+		Params: []object.BuiltinParam{
+			{Name: "a", Type: object.ARRAY_OBJ},
+			{Name: "b", Type: object.ARRAY_OBJ},
+			{Name: "more", Type: object.ARRAY_OBJ, Variadic: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			arrays := make([]*object.Array, len(args))
+			for i, arg := range args {
+				arrays[i] = arg.(*object.Array)
+			}
+
+			length := len(arrays[0].Elements)
+			for _, arr := range arrays[1:] {
+				if len(arr.Elements) < length {
+					length = len(arr.Elements)
+				}
+			}
+
+			elements := make([]object.Object, length)
+			for i := 0; i < length; i++ {
+				tuple := make([]object.Object, len(arrays))
+				for j, arr := range arrays {
+					tuple[j] = arr.Elements[i]
+				}
+
+				elements[i] = &object.Array{Elements: tuple}
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// どれか1つでも関数が truthy を返せば true
+	"any": {
+		Doc: "whether a function returns true for any element of an array",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "fn"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := checkCallable("any", "second", args[1]); err != nil {
+				return err
+			}
+
+			arr, fn := args[0].(*object.Array), args[1]
+
+			for _, el := range arr.Elements {
+				result := applyFunction(fn, []object.Object{el}, env)
+				if isError(result) {
+					return result
+				}
+
+				if isTruthy(result) {
+					return TRUE
+				}
+			}
+
+			return FALSE
+		},
+	},
+	// すべてに対して関数が truthy を返せば true
+	"all": {
+		Doc: "whether a function returns true for all elements of an array",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "fn"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := checkCallable("all", "second", args[1]); err != nil {
+				return err
+			}
+
+			arr, fn := args[0].(*object.Array), args[1]
+
+			for _, el := range arr.Elements {
+				result := applyFunction(fn, []object.Object{el}, env)
+				if isError(result) {
+					return result
+				}
+
+				if !isTruthy(result) {
+					return FALSE
+				}
+			}
+
+			return TRUE
+		},
+	},
+	// 関数が truthy を返す最初の要素。なければ NULL
+	"find": {
+		Doc: "first element of an array for which a function returns true, or null",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "fn"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := checkCallable("find", "second", args[1]); err != nil {
+				return err
+			}
+
+			arr, fn := args[0].(*object.Array), args[1]
+
+			for _, el := range arr.Elements {
+				result := applyFunction(fn, []object.Object{el}, env)
+				if isError(result) {
+					return result
+				}
+
+				if isTruthy(result) {
+					return el
+				}
+			}
+
+			return NULL
+		},
+	},
+	// flatten([1, [2, [3]], 4]) => [1, 2, 3, 4]
+	// 入れ子になった配列をすべて平らにする
+	"flatten": {
+		Doc:    "flatten nested arrays into one array",
+		Params: []object.BuiltinParam{{Name: "arr", Type: object.ARRAY_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return &object.Array{Elements: flattenElements(args[0].(*object.Array), []object.Object{})}
+		},
+	},
+	// unique([1, 2, 1, 3, 2]) => [1, 2, 3]
+	// 最初に出てきたものを残す。要素はハッシュキーとして使えるものに限る
+	"unique": {
+		Doc:    "array without duplicated elements",
+		Params: []object.BuiltinParam{{Name: "arr", Type: object.ARRAY_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			seen := object.NewHash()
+			elements := []object.Object{}
+			for _, el := range args[0].(*object.Array).Elements {
+				key, ok := object.AsHashable(el)
+				if !ok {
+					return newError("unusable as hash key: %s", el.Type())
+				}
+
+				if _, ok := seen.Get(key); ok {
+					continue
+				}
+
+				seen.Set(key, TRUE)
+				elements = append(elements, el)
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+}
+
+// 関数として呼び出せるか
+func isCallable(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Builtin:
+		return true
+	default:
+		return false
+	}
+}
+
+// 関数を受け取る引数を確かめる
+// Monkey の関数も組み込み関数も渡せるので、Params の型では確かめられない
+func checkCallable(name, position string, arg object.Object) *object.Error {
+	if !isCallable(arg) {
+		return newError("%s argument to `%s` must be FUNCTION, got %s", position, name, arg.Type())
+	}
+
+	return nil
+}
+
+func copyElements(arr *object.Array) []object.Object {
+	elements := make([]object.Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+	return elements
+}
+
+// 比較関数なしの sort は、すべて整数かすべて文字列の配列だけを並べ替えられる
+func sortByDefault(elements []object.Object) object.Object {
+	if len(elements) == 0 {
+		return &object.Array{Elements: elements}
+	}
+
+	elementType := elements[0].Type()
+	if elementType != object.INTEGER_OBJ && elementType != object.STRING_OBJ {
+		return newError("`sort` without a comparator supports only INTEGER or STRING elements, got %s", elementType)
+	}
+
+	for _, el := range elements {
+		if el.Type() != elementType {
+			return newError("`sort` without a comparator requires elements of the same type, got %s and %s", elementType, el.Type())
+		}
+	}
+
+	sort.SliceStable(elements, func(i, j int) bool {
+		if elementType == object.INTEGER_OBJ {
+			return elements[i].(*object.Integer).Value < elements[j].(*object.Integer).Value
+		}
+
+		return elements[i].(*object.String).Value < elements[j].(*object.String).Value
+	})
+
+	return &object.Array{Elements: elements}
+}
+
+func flattenElements(arr *object.Array, out []object.Object) []object.Object {
+	for _, el := range arr.Elements {
+		if nested, ok := el.(*object.Array); ok {
+			out = flattenElements(nested, out)
+		} else {
+			out = append(out, el)
+		}
+	}
+
+	return out
+}
+
+// range と seq の引数
+// range(end) / range(start, end) / range(start, end, step)
+var rangeParams = []object.BuiltinParam{
+	{Name: "start", Type: object.INTEGER_OBJ},
+	{Name: "end", Type: object.INTEGER_OBJ, Optional: true},
+	{Name: "step", Type: object.INTEGER_OBJ, Optional: true},
+}
+
+// rangeParams で確かめた引数から、始まり・終わり・刻みを読む
+// 引数が1つなら、それは終わり
+func rangeArgs(name string, args []object.Object) (start, end, step int64, err *object.Error) {
+	var nums []int64
+	for _, arg := range args {
+		nums = append(nums, arg.(*object.Integer).Value)
+	}
+
+	start, end, step = 0, nums[0], 1
+	if len(nums) >= 2 {
+		start, end = nums[0], nums[1]
+	}
+	if len(nums) == 3 {
+		step = nums[2]
+	}
+
+	if step == 0 {
+		return 0, 0, 0, newError("step of `%s` must not be 0", name)
+	}
+
+	return start, end, step, nil
+}
+
+// range(start, end, step) の要素の数
+// 途中の計算があふれないように uint64 で数え、int に収まらなければ収まる一番大きな数にする
+func rangeLength(start, end, step int64) int {
+	var span, stride uint64
+	switch {
+	case step > 0 && start < end:
+		span, stride = uint64(end)-uint64(start), uint64(step)
+	case step < 0 && start > end:
+		span, stride = uint64(start)-uint64(end), -uint64(step)
+	default:
+		return 0
+	}
+
+	n := (span-1)/stride + 1
+	if n > uint64(maxInt) {
+		return maxInt
+	}
+
+	return int(n)
+}
+
+const maxInt = int(^uint(0) >> 1)
@@ -0,0 +1,273 @@
+package evaluator
+
+import (
+	"go-monkey-shakyo/monkey/object"
+	"reflect"
+)
+
+// channel(n) でためておける値の数の上限
+// MaxLength を設定していなくても、巨大なバッファを確保しようとしないように抑える
+const maxChannelSize = 1 << 20
+
+// ゴルーチンで関数を動かし、チャネルで値を受け渡す組み込み関数
+//
+//	let ch = channel();
+//	let tasks = map([1, 2, 3], fn(n) { spawn(fn() { send(ch, n * n) }) });
+//	reduce(range(3), 0, fn(acc, i) { acc + recv(ch) }); // => 14
+//
+// 待つ組み込み関数(wait, send, recv, select)は、評価が取り消されると待つのをやめてエラーを返す
+var concurrencyBuiltins = map[string]*object.Builtin{
+	// spawn(fn, 1, 2) => task
+	// fn(1, 2) を別のゴルーチンで呼び出す。戻り値は wait(task) で受け取る
+	"spawn": {
+		Doc: "call a function on a new goroutine and return a task to wait for",
+		Params: []object.BuiltinParam{
+			{Name: "fn"},
+			{Name: "args", Variadic: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if !isCallable(args[0]) {
+				return newError("first argument to `spawn` must be FUNCTION or BUILTIN, got %s", args[0].Type())
+			}
+
+			task := object.NewTask()
+			go runTask(task, args[0], args[1:], env)
+
+			return task
+		},
+	},
+	// wait(task) => task の関数の戻り値(エラーならそのエラー)
+	// wait(wg) => wgDone でカウンタが 0 になるまで待って null
+	"wait": {
+		Doc:    "wait for a task and return its result, or for a wait group to reach zero",
+		Params: []object.BuiltinParam{{Name: "x"}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			switch x := args[0].(type) {
+			case *object.Task:
+				select {
+				case <-x.Done():
+					return x.Result()
+				case <-env.Done():
+					return checkInterrupted(env)
+				}
+			case *object.WaitGroup:
+				select {
+				case <-x.Wait():
+					return NULL
+				case <-env.Done():
+					return checkInterrupted(env)
+				}
+			default:
+				return newError("argument to `wait` must be TASK or WAIT_GROUP, got %s", args[0].Type())
+			}
+		},
+	},
+	// channel() => 受け取られるまで送る側が待つチャネル
+	// channel(10) => 10個までためておけるチャネル
+	"channel": {
+		Doc:    "new channel that buffers up to size values (default 0)",
+		Params: []object.BuiltinParam{{Name: "size", Type: object.INTEGER_OBJ, Optional: true}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			size := 0
+			if len(args) == 1 {
+				n := args[0].(*object.Integer).Value
+				if n < 0 {
+					return newError("argument to `channel` must not be negative, got %d", n)
+				}
+				if n > maxChannelSize {
+					return exhausted("channel size is too large: %d (max %d)", n, maxChannelSize)
+				}
+				if err := checkSize(env, object.CHANNEL_OBJ, int(n), int(n)*16); err != nil {
+					return err
+				}
+				size = int(n)
+			}
+
+			return object.NewChannel(size)
+		},
+	},
+	// send(ch, value) => null
+	// 受け取られるか、ためておく場所が空くまで待つ
+	"send": {
+		Doc: "send a value to a channel",
+		Params: []object.BuiltinParam{
+			{Name: "ch", Type: object.CHANNEL_OBJ},
+			{Name: "value"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			ch := args[0].(*object.Channel)
+
+			result := selectChannels(env, []reflect.SelectCase{sendCase(ch, args[1])})
+			if isError(result) {
+				return result
+			}
+
+			return NULL
+		},
+	},
+	// recv(ch) => 送られてきた値
+	// 値が来るまで待つ。閉じたチャネルからは、ためてあった値を受け取り終えると null になる
+	"recv": {
+		Doc:    "receive a value from a channel, or null once it is closed and empty",
+		Params: []object.BuiltinParam{{Name: "ch", Type: object.CHANNEL_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			ch := args[0].(*object.Channel)
+
+			result := selectChannels(env, []reflect.SelectCase{recvCase(ch)})
+			if isError(result) {
+				return result
+			}
+
+			return result.(*object.Array).Elements[1]
+		},
+	},
+	// close(ch) => null
+	// 閉じたチャネルには送れない
+	"close": {
+		Doc:    "close a channel",
+		Params: []object.BuiltinParam{{Name: "ch", Type: object.CHANNEL_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := args[0].(*object.Channel).Close(); err != nil {
+				return newError("close: %s", err)
+			}
+
+			return NULL
+		},
+	},
+	// select([ch1, [ch2, value]]) => [0, ch1 から受け取った値] か [1, null]
+	// チャネルは受け取る、[チャネル, 値] は送る。どれか1つができるまで待ち、できたものの位置と受け取った値を返す
+	"select": {
+		Doc:    "wait until one of the receives (ch) or sends ([ch, value]) can proceed and return [index, received value]",
+		Params: []object.BuiltinParam{{Name: "cases", Type: object.ARRAY_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			elements := args[0].(*object.Array).Elements
+			if len(elements) == 0 {
+				return newError("argument to `select` must not be empty")
+			}
+
+			cases := make([]reflect.SelectCase, 0, len(elements))
+			for i, el := range elements {
+				switch el := el.(type) {
+				case *object.Channel:
+					cases = append(cases, recvCase(el))
+				case *object.Array:
+					ch, ok := arrayChannel(el)
+					if !ok {
+						return newError("case %d of `select` must be [CHANNEL, value], got %s", i, el.Inspect())
+					}
+					cases = append(cases, sendCase(ch, el.Elements[1]))
+				default:
+					return newError("case %d of `select` must be CHANNEL or ARRAY, got %s", i, el.Type())
+				}
+			}
+
+			return selectChannels(env, cases)
+		},
+	},
+	// waitGroup() => カウンタが 0 のウェイトグループ
+	"waitGroup": {
+		Doc:    "new wait group to wait for a number of goroutines with wgAdd, wgDone and wait",
+		Params: []object.BuiltinParam{},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return object.NewWaitGroup()
+		},
+	},
+	// wgAdd(wg) / wgAdd(wg, 3) => null
+	"wgAdd": {
+		Doc: "add n (default 1) to the counter of a wait group",
+		Params: []object.BuiltinParam{
+			{Name: "wg", Type: object.WAIT_GROUP_OBJ},
+			{Name: "n", Type: object.INTEGER_OBJ, Optional: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			n := int64(1)
+			if len(args) == 2 {
+				n = args[1].(*object.Integer).Value
+			}
+
+			if err := args[0].(*object.WaitGroup).Add(int(n)); err != nil {
+				return newError("wgAdd: %s", err)
+			}
+
+			return NULL
+		},
+	},
+	// wgDone(wg) => null
+	"wgDone": {
+		Doc:    "subtract one from the counter of a wait group",
+		Params: []object.BuiltinParam{{Name: "wg", Type: object.WAIT_GROUP_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := args[0].(*object.WaitGroup).Add(-1); err != nil {
+				return newError("wgDone: %s", err)
+			}
+
+			return NULL
+		},
+	},
+}
+
+// spawn した関数を呼び出して、戻り値を task に記録する
+// 評価器のバグで panic しても、プロセスごと落とさずに task のエラーにする
+func runTask(task *object.Task, fn object.Object, args []object.Object, env *object.Environment) {
+	var result object.Object
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("spawn: panic: %v", r)
+		}
+		task.Finish(result)
+	}()
+
+	// 別のゴルーチンから呼び出した側のジェネレータに yield しないように、yield の先を持たない環境で呼び出す
+	result = applyFunction(fn, args, object.NewEnclosedEnvironment(env))
+}
+
+// [チャネル, 値] の形の配列か
+func arrayChannel(arr *object.Array) (*object.Channel, bool) {
+	if len(arr.Elements) != 2 {
+		return nil, false
+	}
+
+	ch, ok := arr.Elements[0].(*object.Channel)
+	return ch, ok
+}
+
+func recvCase(ch *object.Channel) reflect.SelectCase {
+	return reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.Chan())}
+}
+
+func sendCase(ch *object.Channel, value object.Object) reflect.SelectCase {
+	v := reflect.New(reflect.TypeOf((*object.Object)(nil)).Elem()).Elem()
+	v.Set(reflect.ValueOf(value))
+
+	return reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.Chan()), Send: v}
+}
+
+// cases のどれか1つができるまで待ち、[できたものの位置, 受け取った値] を返す
+// 送ったときと、閉じたチャネルから受け取ったときの値は null
+// 評価が取り消されたら待つのをやめる
+func selectChannels(env *object.Environment, cases []reflect.SelectCase) (result object.Object) {
+	// 閉じたチャネルに送ると panic するので、エラーにする
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("send on closed channel")
+		}
+	}()
+
+	interrupted := -1
+	if done := env.Done(); done != nil {
+		interrupted = len(cases)
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+
+	chosen, received, ok := reflect.Select(cases)
+	if chosen == interrupted {
+		return checkInterrupted(env)
+	}
+
+	var value object.Object = NULL
+	if ok && received.IsValid() && !received.IsNil() {
+		value = received.Interface().(object.Object)
+	}
+
+	return &object.Array{Elements: []object.Object{&object.Integer{Value: int64(chosen)}, value}}
+}
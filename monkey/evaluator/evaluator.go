@@ -1,11 +1,15 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go-monkey-shakyo/monkey/ast"
 	"go-monkey-shakyo/monkey/object"
 )
 
+// 値が同じならいつも同じものを使う
+// すべてのインタプリタで共有するので、書き換えてはいけない
 var (
 	NULL  = &object.NULL{}
 	TRUE  = &object.Boolean{Value: true}
@@ -13,9 +17,21 @@ var (
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	// 無限ループや無限再帰でも止まるように、評価したノードの数を数える
+	if !env.Step() {
+		return exhausted("step limit exceeded")
+	}
+
 	switch node := node.(type) {
 
 	case *ast.Program:
+		// 止める手段のない環境では、評価し終えたら取り消すコンテキストで、このプログラムだけの評価として評価する
+		// spawn した関数や作ったジェネレータが、プログラムを評価し終えたあとも止まらずに残らないようにする
+		if env.Done() == nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			env = env.NewEvaluation(ctx)
+		}
 		return evalProgram(node.Statements, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
@@ -39,7 +55,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return allocate(env, &object.Integer{Value: node.Value})
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
@@ -60,7 +76,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return allocate(env, evalInfixExpression(node.Operator, left, right))
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
@@ -70,7 +86,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		// params := Eval(node.Parameters) みたいにする必要はないよ
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return allocate(env, &object.Function{Parameters: params, Body: body, Env: env, Generator: node.Generator})
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -89,9 +105,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 
 		// function が Environment を持っているのがポイント！
-		return applyFunction(function, args)
+		return applyFunction(function, args, env)
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return allocate(env, &object.String{Value: node.Value})
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -100,7 +116,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return elements[0]
 		}
 
-		return &object.Array{Elements: elements}
+		return allocate(env, &object.Array{Elements: elements})
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
@@ -113,10 +129,21 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 
-		return evalIndexExpression(left, index)
+		return evalIndexExpression(left, index, env)
+
+	case *ast.SliceExpression:
+		return allocate(env, evalSliceExpression(node, env))
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return allocate(env, evalHashLiteral(node, env))
+
+	case *ast.ImportExpression:
+		return evalImportExpression(node, env)
+	case *ast.ExportStatement:
+		// 束縛そのものは let 文と同じ。どれを公開するかはモジュールを読み込む側が決める
+		return Eval(node.Statement, env)
+	case *ast.YieldStatement:
+		return evalYieldStatement(node, env)
 	}
 
 	return nil
@@ -134,6 +161,10 @@ func evalProgram(statements []ast.Statement, env *object.Environment) object.Obj
 	var result object.Object
 
 	for _, statement := range statements {
+		if err := checkInterrupted(env); err != nil {
+			return err
+		}
+
 		result = Eval(statement, env)
 
 		switch result := result.(type) {
@@ -151,6 +182,10 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	var result object.Object
 
 	for _, statement := range block.Statements {
+		if err := checkInterrupted(env); err != nil {
+			return err
+		}
+
 		result = Eval(statement, env)
 
 		if result != nil {
@@ -163,6 +198,11 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 		}
 	}
 
+	// 空のブロックや let 文で終わるブロックは値を持たないので NULL とする
+	if result == nil {
+		return NULL
+	}
+
 	return result
 }
 
@@ -209,9 +249,9 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -232,6 +272,9 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero: %d / %d", leftVal, rightVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -281,6 +324,23 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// 評価のコンテキストが取り消されたり期限が過ぎたりしていれば、評価を止めるエラーを返す
+// ふつうのエラーと区別できるように、Err に原因(context.Canceled など)を入れる
+func checkInterrupted(env *object.Environment) *object.Error {
+	err := env.Interrupted()
+	if err == nil {
+		return nil
+	}
+
+	return &object.Error{Message: "evaluation interrupted: " + err.Error(), Err: err}
+}
+
+// 評価が取り消しや期限切れで止まったことを表すエラーか
+func IsInterrupted(obj object.Object) bool {
+	errObj, ok := obj.(*object.Error)
+	return ok && (errors.Is(errObj.Err, context.Canceled) || errors.Is(errObj.Err, context.DeadlineExceeded))
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -293,7 +353,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := lookupBuiltin(env, node.Value); ok {
 		return builtin
 	}
 
@@ -316,15 +376,59 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// Go のコードから Monkey の関数や組み込み関数を呼び出す
+// env は組み込み関数に渡す環境(設定や資源の上限はここから使う)
+func Apply(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	return applyFunction(fn, args, env)
+}
+
+// env は呼び出した側の環境。組み込み関数はこの環境の設定を使う
+// (Monkeyの関数は、定義したときの環境を拡張した環境で評価する)
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	if err := checkInterrupted(env); err != nil {
+		return err
+	}
+
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), len(fn.Parameters))
+		}
+
+		if max := env.Limits().MaxDepth; max != 0 && env.Depth() >= max {
+			return exhausted("call depth limit exceeded (max %d)", max)
+		}
+
+		if !env.Allocate(envSize(len(args))) {
+			return exhausted("memory limit exceeded (max %d bytes)", env.Limits().MaxAlloc)
+		}
+
 		extendedEnv := extendFunctionEnv(fn, args)
+		extendedEnv.SetDepth(env.Depth() + 1)
+		extendedEnv.ShareUsage(env)
+
+		// ジェネレータ関数は本体を評価せず、next で値を求められたときに少しずつ評価する
+		if fn.Generator {
+			return newGenerator(fn, extendedEnv)
+		}
+
+		// ジェネレータの中で呼び出した関数の yield 文も、そのジェネレータに値を渡す
+		extendedEnv.SetYield(env.Yield())
+
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if fn.Requires != "" && !env.Settings().Allows(fn.Requires) {
+			return newError("`%s` requires the %s capability, which is not granted", fn.Name, fn.Requires)
+		}
+
+		if err := checkBuiltinArgs(fn, args); err != nil {
+			return err
+		}
+
+		// 組み込み関数が作った値も数える(引数をそのまま返したときも数えるので、多めになる)
+		return allocate(env, fn.Fn(env, args...))
 
 	default:
 		return newError("not a function: %s", fn.Type())
@@ -347,6 +451,26 @@ func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Enviro
 	return env
 }
 
+// yield <expression>; の値を一番近いジェネレータに渡して、次の値を求められるまで待つ
+func evalYieldStatement(ys *ast.YieldStatement, env *object.Environment) object.Object {
+	val := Eval(ys.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	yield := env.Yield()
+	if yield == nil {
+		return newError("yield outside generator")
+	}
+
+	// ジェネレータを作った評価が終わったら、本体の評価もやめる
+	if !yield(val) {
+		return newError("generator was stopped: the evaluation that created it has ended")
+	}
+
+	return NULL
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -357,48 +481,34 @@ func unwrapReturnValue(obj object.Object) object.Object {
 
 // 文字列同士の中置演算式の評価
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
-}
 
-func evalIndexExpression(left, index object.Object) object.Object {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return evalArrayIndexExpression(left, index)
-	case left.Type() == object.HASH_OBJ:
-		return evalHashIndexExpression(left, index)
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("index operator not supported: %s", left.Type())
-	}
-}
-
-func evalArrayIndexExpression(array, index object.Object) object.Object {
-	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
-
-	if idx < 0 || idx > max {
-		return NULL
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
-
-	return arrayObject.Elements[idx]
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
+
+	// ソースコードに書いた順に評価して追加する
+	for _, keyNode := range node.Keys {
+		valueNode := node.Pairs[keyNode]
 
-	for keyNode, valueNode := range node.Pairs {
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
@@ -408,25 +518,8 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
-	}
-
-	return &object.Hash{Pairs: pairs}
-}
-
-func evalHashIndexExpression(hash object.Object, index object.Object) object.Object {
-	hashObject := hash.(*object.Hash)
-
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
-	}
-
-	pair, ok := hashObject.Pairs[key.HashKey()]
-	if !ok {
-		return NULL
+		hash.Set(hashKey, value)
 	}
 
-	return pair.Value
+	return hash
 }
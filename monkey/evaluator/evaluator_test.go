@@ -1,10 +1,21 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"go-monkey-shakyo/monkey/lexer"
 	"go-monkey-shakyo/monkey/object"
 	"go-monkey-shakyo/monkey/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 // 整数リテラルを含む式文が与えられたときの評価
@@ -98,6 +109,25 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"両方のオペランドが真偽値の場合の中置演算", "(1 < 2) == false", false},
 		{"両方のオペランドが真偽値の場合の中置演算", "(1 > 2) == true", false},
 		{"両方のオペランドが真偽値の場合の中置演算", "(1 > 2) == false", true},
+
+		// 文字列、配列、ハッシュ、null は中身を比べる
+		{"文字列の等値比較", `"a" == "a"`, true},
+		{"文字列の等値比較", `"a" == "b"`, false},
+		{"文字列の等値比較", `"a" != "b"`, true},
+		{"配列の等値比較", "[1, 2] == [1, 2]", true},
+		{"配列の等値比較", "[1, 2] == [2, 1]", false},
+		{"配列の等値比較", "[1, 2] == [1, 2, 3]", false},
+		{"配列の等値比較", "[1, [2, 3]] != [1, [2, 3]]", false},
+		{"ハッシュの等値比較: 順番は問わない", `{"a": 1, "b": 2} == {"b": 2, "a": 1}`, true},
+		{"ハッシュの等値比較", `{"a": 1} == {"a": 2}`, false},
+		{"ハッシュの等値比較", `{"a": 1} == {"a": 1, "b": 2}`, false},
+		{"ハッシュの等値比較: 値に配列", `{"a": [1]} == {"a": [1]}`, true},
+		{"nullの等値比較", "if (false) { 1 } == if (false) { 2 }", true},
+		{"nullの等値比較", "if (false) { 1 } == 0", false},
+		{"型の違う値は等しくない", `1 == "1"`, false},
+		{"型の違う値は等しくない", `[1] != {1: 1}`, true},
+		{"関数は同じものだけが等しい", "let f = fn(x) { x }; f == f", true},
+		{"関数は同じものだけが等しい", "fn(x) { x } == fn(x) { x }", false},
 	}
 
 	for _, tt := range tests {
@@ -287,6 +317,21 @@ if (10 > 1) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"Hashableでないオブジェクトを含む配列もハッシュキーにできない",
+			`{"name": "Monkey"}[[1, fn(x) { x }]];`,
+			"unusable as hash key: ARRAY",
+		},
+		{
+			"0で割ることはできない",
+			"10 / (5 - 5)",
+			"division by zero: 10 / 0",
+		},
+		{
+			"関数の引数の数がパラメータの数と違うとエラーになる",
+			"fn(x, y) { x + y }(1)",
+			"wrong number of arguments. got=1, want=2",
+		},
 	}
 
 	for _, tt := range tests {
@@ -307,6 +352,141 @@ if (10 > 1) {
 	}
 }
 
+// ステップ数の上限を設定すると、終わらないプログラムもエラーで止まる
+func TestStepLimit(t *testing.T) {
+	input := "let f = fn(x) { f(x + 1) }; f(0);"
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	env := object.NewEnvironment()
+	env.SetLimits(object.Limits{MaxSteps: 1000})
+
+	evaluated := Eval(program, env)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	if errObj.Message != "step limit exceeded" {
+		t.Errorf("wrong error message. expected=%q, got=%q", "step limit exceeded", errObj.Message)
+	}
+}
+
+// 呼び出しの深さ・値の長さ・作った値の大きさの上限
+func TestResourceLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   object.Limits
+		input    string
+		expected interface{}
+	}{
+		{"呼び出しの深さ", object.Limits{MaxDepth: 50}, "let f = fn(x) { f(x + 1) }; f(0);", "call depth limit exceeded (max 50)"},
+		{"呼び出しの深さ: 上限まで", object.Limits{MaxDepth: 50}, "let f = fn(x) { if (x < 49) { f(x + 1) } else { x } }; f(0);", 49},
+		{"呼び出しの深さ: 戻れば数え直す", object.Limits{MaxDepth: 3}, "let f = fn(x) { x }; f(1) + f(2) + f(3) + f(4);", 10},
+		{"配列の長さ: push", object.Limits{MaxLength: 3}, "push(push(push([], 1), 2), 3)", []interface{}{1, 2, 3}},
+		{"配列の長さ: push で超える", object.Limits{MaxLength: 3}, "push([1, 2, 3], 4)", "length limit exceeded: ARRAY of length 4 (max 3)"},
+		{"配列の長さ: リテラル", object.Limits{MaxLength: 3}, "[1, 2, 3, 4]", "length limit exceeded: ARRAY of length 4 (max 3)"},
+		{"配列の長さ: range", object.Limits{MaxLength: 1000}, "range(1000000000000)", "length limit exceeded: ARRAY of length 1000000000000 (max 1000)"},
+		{"配列の長さ: range の刻み", object.Limits{MaxLength: 4}, "range(0, 10, 3)", []interface{}{0, 3, 6, 9}},
+		{"文字列の長さ: 連結", object.Limits{MaxLength: 5}, `"abc" + "def"`, "length limit exceeded: STRING of length 6 (max 5)"},
+		{"文字列の長さ: repeat", object.Limits{MaxLength: 100}, `repeat("ab", 1000000000000)`, "length limit exceeded: STRING of length 2000000000000 (max 100)"},
+		{"文字列の長さ: replace", object.Limits{MaxLength: 65536}, `let s = repeat("a", 60000); replace(s, "a", s)`, "length limit exceeded: STRING of length 3600000000 (max 65536)"},
+		{"文字列の長さ: join", object.Limits{MaxLength: 100}, `join(map(range(20), fn(i) { "aaaaaaaaaa" }), ",")`, "length limit exceeded: STRING of length 219 (max 100)"},
+		{"文字列の長さ: format", object.Limits{MaxLength: 10}, `format("%s-%s", "aaaaaaaa", "bbbbbbbb")`, "length limit exceeded: STRING of length 17 (max 10)"},
+		{"文字列の長さ: jsonStringify の字下げ", object.Limits{MaxLength: 100}, `jsonStringify([[[[[1]]]]], 10)`, "length limit exceeded: STRING of length 271 (max 100)"},
+		{"ハッシュの要素数", object.Limits{MaxLength: 1}, `merge({"a": 1}, {"b": 2})`, "length limit exceeded: HASH of length 2 (max 1)"},
+		{"作った値の大きさ", object.Limits{MaxAlloc: 10000}, "let f = fn(arr) { f(push(arr, 1)) }; f([]);", "memory limit exceeded (max 10000 bytes)"},
+		{"作った値の大きさ: range", object.Limits{MaxAlloc: 10000}, "range(100000)", "memory limit exceeded (max 10000 bytes)"},
+		{"作った値の大きさ: 巨大な range", object.Limits{MaxAlloc: 1 << 20}, "range(0, 9000000000000000000)", "memory limit exceeded (max 1048576 bytes)"},
+		{"作った値の大きさ: 上限まで", object.Limits{MaxAlloc: 10000}, "len(range(10))", 10},
+		{"ステップ数", object.Limits{MaxSteps: 100}, "let f = fn(x) { f(x + 1) }; f(0);", "step limit exceeded"},
+		{"反復子は配列を作らない", object.Limits{MaxLength: 1000}, "reduce(seq(100000), 0, fn(acc, x) { acc + x })", 4999950000},
+		{"反復子を集めると長さを確かめる", object.Limits{MaxLength: 1000}, "collect(seq(100000))", "length limit exceeded: ARRAY of length 1001 (max 1000)"},
+		{"終わらないジェネレータを集める", object.Limits{MaxLength: 10}, "let ones = fn*() { let loop = fn() { yield 1; loop() }; loop() }; collect(ones())", "length limit exceeded: ARRAY of length 11 (max 10)"},
+		{"ジェネレータの本体のステップ数", object.Limits{MaxSteps: 1000}, "let g = fn*() { let loop = fn() { loop() }; loop() }; next(g())", "step limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			env := object.NewEnvironment()
+			env.SetLimits(tt.limits)
+
+			evaluated := Eval(program, env)
+
+			message, ok := tt.expected.(string)
+			if !ok {
+				testObject(t, evaluated, tt.expected)
+				return
+			}
+
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			}
+			if errObj.Message != message {
+				t.Errorf("wrong error message. expected=%q, got=%q", message, errObj.Message)
+			}
+			if !errors.Is(errObj.Err, object.ErrResourceExhausted) {
+				t.Errorf("error is not marked as resource exhausted: %+v", errObj)
+			}
+		})
+	}
+}
+
+// コンテキストが取り消されると、終わらないプログラムも次の文か関数呼び出しで止まる
+func TestInterrupt(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"無限再帰", "let f = fn(x) { f(x + 1) }; f(0);"},
+		{"組み込み関数から呼ぶ関数", "let f = fn(x) { map([x], f) }; f(0);"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			env := object.NewEnvironment()
+			env.SetContext(ctx)
+
+			evaluated := Eval(program, env)
+			if !IsInterrupted(evaluated) {
+				t.Fatalf("evaluation was not interrupted. got=%T(%+v)", evaluated, evaluated)
+			}
+
+			errObj := evaluated.(*object.Error)
+			if errObj.Message != "evaluation interrupted: context deadline exceeded" {
+				t.Errorf("wrong error message. got=%q", errObj.Message)
+			}
+		})
+	}
+
+	// 取り消されたコンテキストでは1文も評価しない
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	env := object.NewEnvironment()
+	env.SetContext(ctx)
+	Eval(parser.New(lexer.New("let x = 1;")).ParseProgram(), env)
+	if _, ok := env.Get("x"); ok {
+		t.Errorf("statement was evaluated after cancellation")
+	}
+
+	// ふつうのエラーは取り消しとは区別する
+	if IsInterrupted(testEval(`1 + true`)) {
+		t.Errorf("a runtime error is reported as an interruption")
+	}
+}
+
 // let文において値を生成する式の評価と、名前に束縛された識別子の評価をしている
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
@@ -475,7 +655,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{
 			"len(): エラー: 引数は1つでなければいけない",
 			`len("one", "two")`,
-			"wrong number of arguments. got=2, want=1",
+			"wrong number of arguments to `len`. got=2, want=1",
 		},
 		{
 			"len(): 配列の要素数を取得できる",
@@ -500,7 +680,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{
 			"first(): エラー",
 			`first(1)`,
-			"argument to `first` must be ARRAY, got INTEGER",
+			"argument `arr` to `first` must be ARRAY, got INTEGER",
 		},
 		{
 			"last(): 配列の最後の要素を取得できる",
@@ -515,7 +695,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{
 			"last(): エラー",
 			`last(1)`,
-			"argument to `last` must be ARRAY, got INTEGER",
+			"argument `arr` to `last` must be ARRAY, got INTEGER",
 		},
 		{
 			"rest(): cdrと同じ動き。与えられた配列の最初の1つを除いて残りを全て含む新しい配列を返す。",
@@ -535,7 +715,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{
 			"push(): エラー",
 			`push(1, 1)`,
-			"argument to `push` must be ARRAY, got INTEGER",
+			"argument `arr` to `push` must be ARRAY, got INTEGER",
 		},
 	}
 
@@ -650,8 +830,18 @@ func TestArrayIndexExpressions(t *testing.T) {
 			nil,
 		},
 		{
-			"マイナスの添字アクセスはNULL",
+			"マイナスの添字は末尾から数える",
 			"[1, 2, 3][-1]",
+			3,
+		},
+		{
+			"マイナスの添字は末尾から数える",
+			"[1, 2, 3][-3]",
+			1,
+		},
+		{
+			"範囲外のマイナスの添字アクセスはNULL",
+			"[1, 2, 3][-4]",
 			nil,
 		},
 	}
@@ -687,21 +877,21 @@ func TestHashLiterals(t *testing.T) {
 		t.Fatalf("Eval didn't return Hash. got=%T(%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := map[object.Hashable]int64{
+		&object.String{Value: "one"}:   1,
+		&object.String{Value: "two"}:   2,
+		&object.String{Value: "three"}: 3,
+		&object.Integer{Value: 4}:      4,
+		TRUE:                           5,
+		FALSE:                          6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := result.Get(expectedKey)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
@@ -753,6 +943,21 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			"ハッシュキーに配列も使える",
+			`{[1, 2]: 5}[[1, 2]]`,
+			5,
+		},
+		{
+			"配列のキーは要素の順番も比べる",
+			`{[1, 2]: 5}[[2, 1]]`,
+			nil,
+		},
+		{
+			"ハッシュキーにハッシュも使える(ペアの順番は問わない)",
+			`{{"a": 1, "b": 2}: 5}[{"b": 2, "a": 1}]`,
+			5,
+		},
 	}
 
 	for _, tt := range tests {
@@ -767,3 +972,1037 @@ func TestHashIndexExpressions(t *testing.T) {
 		})
 	}
 }
+
+// 任意の入力に対して、評価器がパニックせず、ステップ数の上限内で終了すること
+func FuzzEval(f *testing.F) {
+	// 既存のテストの入力を種にする
+	seeds := []string{
+		"5 + 5 + 5 + 5 - 10", "(5 + 10 * 2 + 15 / 3) * 2 + -10", "(1 < 2) == true", "!!5",
+		"if (1 < 2) { 10 } else { 20 }", "9; return 2 * 5; 9;",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		"5 + true; 5;", "-true", `"Hello" - "World"`, `{"name": "Monkey"}[fn(x) { x }];`,
+		"let a = 5; let b = a; let c = a + b + 5; c;",
+		"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", "fn(x) { x; }(5)",
+		"let newAdder = fn(x) { fn(y) { x + y }; }; let addTwo = newAdder(2); addTwo(2);",
+		`"Hello" + " " + "World!"`, `len("four")`, "len(1)", `len("one", "two")`,
+		"first([1, 2, 3])", "last([1, 2, 3])", "rest([1, 2, 3])", "push([], 1)", "puts(1)",
+		"[1, 2 * 2, 3 + 3]", "let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];",
+		"[1, 2, 3][-1]", `let two = "two"; {"one": 10 - 9, two: 1 + 1, "thr" + "ee": 6 / 2, 4: 4, true: 5, false: 6}`,
+		`{"foo": 5}["foo"]`, "{true: 5}[true]", "let f = fn(x) { f(x + 1) }; f(0);", "10 / 0",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Diagnostics()) != 0 {
+			return
+		}
+
+		// recv や wait で止まったり、range や repeat で大きな値を作ったりしても終わるようにする
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		env := object.NewEnvironment()
+		env.SetLimits(object.Limits{MaxSteps: 10000, MaxLength: 10000, MaxAlloc: 1 << 20})
+		env.SetContext(ctx)
+		// ファイルや環境変数、標準出力には触らせない
+		env.Settings().Capabilities = object.NewCapabilities()
+
+		evaluated := Eval(program, env)
+		if evaluated != nil {
+			evaluated.Inspect()
+		}
+	})
+}
+
+// ディレクトリにモジュールのファイルを作って、そのディレクトリを起点に評価する
+func testEvalWithModules(t *testing.T, files map[string]string, input string, searchPath ...string) object.Object {
+	dir := t.TempDir()
+
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+
+	env := object.NewEnvironment()
+	env.SetDir(dir)
+	for _, sp := range searchPath {
+		env.Modules().SearchPath = append(env.Modules().SearchPath, filepath.Join(dir, sp))
+	}
+
+	return Eval(program, env)
+}
+
+func TestImport(t *testing.T) {
+	files := map[string]string{
+		"lib/math.monkey": `
+let square = fn(x) { x * x };
+export let fourth = fn(x) { square(square(x)) };
+export let two = 2;
+`,
+		// import のパスは import する側のファイルからの相対パス
+		"lib/util.monkey": `
+let math = import "./math";
+export let sixteen = math["fourth"](math["two"]);
+`,
+		"vendor/greet.monkey": `export let hello = fn(name) { "Hello " + name };`,
+		"cycle/a.monkey":      `import "b";`,
+		"cycle/b.monkey":      `import "a";`,
+		"broken.monkey":       `let = 1;`,
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"exportされた束縛を使える", `let m = import "lib/math"; m["fourth"](3)`, 81},
+		{"拡張子は省略できる", `import "lib/math.monkey"["two"]`, 2},
+		{"モジュールから別のモジュールを相対パスで読み込む", `import "lib/util"["sixteen"]`, 16},
+		{"検索パスからモジュールを探す", `import "greet"["hello"]("Monkey")`, "Hello Monkey"},
+		{"同じモジュールは一度だけ評価される", `import "lib/math" == import "./lib/math"`, true},
+		{"exportされていない束縛は見えない", `import "lib/math"["square"]`, "module %s does not export square"},
+		{"モジュールが見つからない", `import "nothing"`, `module not found: "nothing"`},
+		{"循環したimportはエラーになる", `import "cycle/a"`, "import cycle: %s -> %s -> %s"},
+		{"モジュールの構文エラー", `import "broken"`, `parse error in module "broken": 1:5: error[E0001]: expected next token to be IDENT, got = instead`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated := testEvalWithModules(t, files, tt.input, "vendor")
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case string:
+				switch obj := evaluated.(type) {
+				case *object.String:
+					if obj.Value != expected {
+						t.Errorf("String has wrong value. got=%q, want=%q", obj.Value, expected)
+					}
+				case *object.Error:
+					// エラーメッセージの %s は一時ディレクトリのパスなので、前後だけを確かめる
+					parts := strings.Split(expected, "%s")
+					if !strings.HasPrefix(obj.Message, parts[0]) || !strings.HasSuffix(obj.Message, parts[len(parts)-1]) {
+						t.Errorf("wrong error message. expected=%q, got=%q", expected, obj.Message)
+					}
+				default:
+					t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+				}
+			}
+		})
+	}
+}
+
+// 起点のディレクトリと検索パスの外にあるモジュールは読み込めない
+func TestImportConfinement(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	if err := os.MkdirAll(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		filepath.Join(base, "secret.monkey"): `export let secret = 42;`,
+		filepath.Join(root, "ok.monkey"):     `export let ok = 1;`,
+		filepath.Join(root, "lib/up.monkey"): `export let ok = import "../ok"["ok"];`,
+	}
+	for path, src := range files {
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(base, "secret.monkey"), filepath.Join(root, "link.monkey")); err != nil {
+		t.Skipf("symlinks are not supported: %s", err)
+	}
+
+	tests := []struct {
+		name         string
+		input        string
+		capabilities object.Capabilities
+		expected     interface{}
+	}{
+		{"モジュールから起点のディレクトリの中を相対パスで読み込める", `import "lib/up"["ok"]`, nil, 1},
+		{"エラー: 外を指す相対パス", `import "../secret"`, nil, &object.Error{Message: `import "../secret": path escapes the module path`}},
+		{"エラー: 途中で外に出るパス", `import "lib/../../secret"`, nil, &object.Error{Message: `import "lib/../../secret": path escapes the module path`}},
+		{"エラー: 外を指すシンボリックリンク", `import "link"`, nil, &object.Error{Message: `import "link": path escapes the module path`}},
+		{"エラー: 絶対パス", `import "/etc/passwd"`, nil, &object.Error{Message: `import path must be relative: "/etc/passwd"`}},
+		{"エラー: 権限がない", `import "ok"`, object.NewCapabilities(), &object.Error{Message: "`import` requires the modules capability, which is not granted"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			env := object.NewEnvironment()
+			env.SetDir(root)
+			env.Settings().Capabilities = tt.capabilities
+
+			testObject(t, Eval(program, env), tt.expected)
+		})
+	}
+}
+
+// 期待する値の型に応じて評価結果を確かめる
+// *object.Error を期待するときはメッセージを比べる
+func testObject(t *testing.T, obj object.Object, expected interface{}) bool {
+	switch expected := expected.(type) {
+	case nil:
+		return testNullObject(t, obj)
+	case int:
+		return testIntegerObject(t, obj, int64(expected))
+	case bool:
+		return testBooleanObject(t, obj, expected)
+	case string:
+		str, ok := obj.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", obj, obj)
+			return false
+		}
+
+		if str.Value != expected {
+			t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			return false
+		}
+	case []interface{}:
+		array, ok := obj.(*object.Array)
+		if !ok {
+			t.Errorf("object is not Array. got=%T (%+v)", obj, obj)
+			return false
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d (%s)", len(expected), len(array.Elements), array.Inspect())
+			return false
+		}
+
+		for i, expectedElem := range expected {
+			if !testObject(t, array.Elements[i], expectedElem) {
+				return false
+			}
+		}
+	case *object.Error:
+		errObj, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error. got=%T (%+v)", obj, obj)
+			return false
+		}
+
+		if errObj.Message != expected.Message {
+			t.Errorf("wrong error message. expected=%q, got=%q", expected.Message, errObj.Message)
+			return false
+		}
+	default:
+		t.Fatalf("unsupported expected type %T", expected)
+	}
+
+	return true
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"split(): 区切り文字で分割する", `split("a,b,c", ",")`, []interface{}{"a", "b", "c"}},
+		{"split(): 区切り文字がなければ1要素", `split("abc", ",")`, []interface{}{"abc"}},
+		{"split(): エラー: 引数は2つ", `split("abc")`, &object.Error{Message: "wrong number of arguments to `split`. got=1, want=2"}},
+		{"split(): エラー: 文字列以外", `split(1, ",")`, &object.Error{Message: "argument `s` to `split` must be STRING, got INTEGER"}},
+		{"join(): 区切り文字でつなげる", `join(["a", "b", "c"], "-")`, "a-b-c"},
+		{"join(): 空の配列は空文字", `join([], "-")`, ""},
+		{"join(): エラー: 配列以外", `join("abc", "-")`, &object.Error{Message: "argument `arr` to `join` must be ARRAY, got STRING"}},
+		{"join(): エラー: 文字列以外の要素", `join(["a", 1], "-")`, &object.Error{Message: "elements of the array passed to `join` must be STRING, got INTEGER at index 1"}},
+		{"trim(): 前後の空白を取り除く", "trim(\"  hello \t\")", "hello"},
+		{"upper(): 大文字にする", `upper("Monkey")`, "MONKEY"},
+		{"lower(): 小文字にする", `lower("Monkey")`, "monkey"},
+		{"lower(): エラー: 文字列以外", `lower(true)`, &object.Error{Message: "argument `s` to `lower` must be STRING, got BOOLEAN"}},
+		{"replace(): すべて置き換える", `replace("banana", "a", "o")`, "bonono"},
+		{"replace(): エラー: 引数は3つ", `replace("banana", "a")`, &object.Error{Message: "wrong number of arguments to `replace`. got=2, want=3"}},
+		{"contains(): 含む", `contains("monkey", "key")`, true},
+		{"contains(): 含まない", `contains("monkey", "dog")`, false},
+		{"startsWith(): 前方一致", `startsWith("monkey", "mon")`, true},
+		{"endsWith(): 後方一致", `endsWith("monkey", "mon")`, false},
+		{"indexOf(): 見つかった位置", `indexOf("monkey", "key")`, 3},
+		{"indexOf(): 見つからなければ-1", `indexOf("monkey", "dog")`, -1},
+		{"substr(): 開始位置から最後まで", `substr("monkey", 3)`, "key"},
+		{"substr(): 開始位置から長さ分", `substr("monkey", 1, 3)`, "onk"},
+		{"substr(): はみ出す分は切り詰める", `substr("monkey", 4, 10)`, "ey"},
+		{"substr(): 長さが大きくてもあふれない", `substr("abc", 1, 9223372036854775807)`, "bc"},
+		{"substr(): エラー: 負の位置", `substr("monkey", -1)`, &object.Error{Message: "arguments to `substr` must not be negative, got start=-1, length=6"}},
+		{"substr(): エラー: 位置は整数", `substr("monkey", "1")`, &object.Error{Message: "argument `start` to `substr` must be INTEGER, got STRING"}},
+		{"repeat(): 繰り返す", `repeat("ab", 3)`, "ababab"},
+		{"repeat(): エラー: 負の回数", `repeat("ab", -1)`, &object.Error{Message: "second argument to `repeat` must not be negative, got -1"}},
+		{"repeat(): エラー: 長さがあふれる", `repeat("ab", 9223372036854775807)`, &object.Error{Message: "result of `repeat` is too long: 2 bytes * 9223372036854775807"}},
+		{"format(): 書式を展開する", `format("%s is %d years old (100%%)", "Monkey", 3)`, "Monkey is 3 years old (100%)"},
+		{"format(): %sはどんな値でも", `format("%s %s", [1, 2], true)`, "[1, 2] true"},
+		{"format(): エラー: %dに整数以外", `format("%d", "a")`, &object.Error{Message: "%d in format string must be INTEGER, got STRING"}},
+		{"format(): エラー: 引数が足りない", `format("%s %s", 1)`, &object.Error{Message: "missing argument for %s in format string"}},
+		{"format(): エラー: 引数が多すぎる", `format("%s", 1, 2)`, &object.Error{Message: "too many arguments for format string. got=2, want=1"}},
+		{"format(): エラー: 知らない書式", `format("%x", 1)`, &object.Error{Message: "unknown verb %x in format string"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated := testEval(tt.input)
+			testObject(t, evaluated, tt.expected)
+		})
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"map(): 各要素に関数を適用する", `map([1, 2, 3], fn(x) { x * 2 })`, []interface{}{2, 4, 6}},
+		{"map(): 組み込み関数も渡せる", `map(["a", "bb"], len)`, []interface{}{1, 2}},
+		{"map(): エラー: 関数以外", `map([1], 1)`, &object.Error{Message: "second argument to `map` must be FUNCTION, got INTEGER"}},
+		{"map(): 関数の中のエラーで中断する", `map([1, 2], fn(x) { x + true })`, &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{"filter(): 条件に合う要素だけを残す", `filter([1, 2, 3, 4], fn(x) { x > 2 })`, []interface{}{3, 4}},
+		{"filter(): エラー: 配列以外", `filter(1, fn(x) { x })`, &object.Error{Message: "argument `arr` to `filter` must be ARRAY, got INTEGER"}},
+		{"reduce(): 畳み込む", `reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{"reduce(): 空の配列は初期値", `reduce([], 5, fn(acc, x) { acc + x })`, 5},
+		{"reduce(): エラー: 引数は3つ", `reduce([1], fn(acc, x) { acc + x })`, &object.Error{Message: "wrong number of arguments to `reduce`. got=2, want=3"}},
+		{"sort(): 整数を並べ替える", `sort([3, 1, 2])`, []interface{}{1, 2, 3}},
+		{"sort(): 文字列を並べ替える", `sort(["b", "c", "a"])`, []interface{}{"a", "b", "c"}},
+		{"sort(): 比較関数で並べ替える", `sort([3, 1, 2], fn(a, b) { a > b })`, []interface{}{3, 2, 1}},
+		{"sort(): 元の配列は変わらない", `let a = [2, 1]; sort(a); a`, []interface{}{2, 1}},
+		{"sort(): エラー: 型が混ざっている", `sort([1, "a"])`, &object.Error{Message: "`sort` without a comparator requires elements of the same type, got INTEGER and STRING"}},
+		{"sort(): 比較関数の中のエラー", `sort([1, 2], fn(a, b) { a + true })`, &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{"reverse(): 配列を逆順にする", `reverse([1, 2, 3])`, []interface{}{3, 2, 1}},
+		{"reverse(): 文字列を逆順にする", `reverse("abc")`, "cba"},
+		{"range(): 0からn-1まで", `range(3)`, []interface{}{0, 1, 2}},
+		{"range(): startからend-1まで", `range(1, 4)`, []interface{}{1, 2, 3}},
+		{"range(): stepごと", `range(0, 10, 3)`, []interface{}{0, 3, 6, 9}},
+		{"range(): 負のstep", `range(3, 0, -1)`, []interface{}{3, 2, 1}},
+		{"range(): 終わりの近くであふれない", `range(9223372036854775806, 9223372036854775807, 2)`, []interface{}{9223372036854775806}},
+		{"range(): 終わりの近くであふれない(負のstep)", `range(-9223372036854775806, -9223372036854775807, -2)`, []interface{}{-9223372036854775806}},
+		{"range(): エラー: stepが0", `range(0, 3, 0)`, &object.Error{Message: "step of `range` must not be 0"}},
+		{"zip(): 短い方に揃える", `zip([1, 2], ["a", "b", "c"])`, []interface{}{[]interface{}{1, "a"}, []interface{}{2, "b"}}},
+		{"zip(): エラー: 配列以外", `zip([1], 2)`, &object.Error{Message: "argument `b` to `zip` must be ARRAY, got INTEGER"}},
+		{"any(): 1つでも条件に合う", `any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{"any(): 空の配列はfalse", `any([], fn(x) { true })`, false},
+		{"all(): すべて条件に合う", `all([1, 2, 3], fn(x) { x > 0 })`, true},
+		{"all(): 条件に合わないものがある", `all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{"find(): 最初に条件に合う要素", `find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{"find(): 見つからなければNULL", `find([1, 2, 3], fn(x) { x > 5 })`, nil},
+		{"flatten(): 入れ子をすべて平らにする", `flatten([1, [2, [3, []]], 4])`, []interface{}{1, 2, 3, 4}},
+		{"unique(): 重複を取り除く", `unique([1, 2, 1, 3, 2])`, []interface{}{1, 2, 3}},
+		{"unique(): 文字列の重複", `unique(["a", "b", "a"])`, []interface{}{"a", "b"}},
+		{"unique(): 配列の重複", `unique([[1, 2], [1, 2], [2, 1]])`, []interface{}{[]interface{}{1, 2}, []interface{}{2, 1}}},
+		{"unique(): エラー: ハッシュキーにできない要素", `unique([fn(x) { x }])`, &object.Error{Message: "unusable as hash key: FUNCTION"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated := testEval(tt.input)
+			testObject(t, evaluated, tt.expected)
+		})
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"keys(): 書いた順にキーを返す", `keys({"b": 1, "a": 2, "c": 3})`, []interface{}{"b", "a", "c"}},
+		{"keys(): 空のハッシュ", `keys({})`, []interface{}{}},
+		{"keys(): エラー: ハッシュ以外", `keys([1])`, &object.Error{Message: "argument `hash` to `keys` must be HASH, got ARRAY"}},
+		{"values(): 書いた順に値を返す", `values({"b": 1, "a": 2})`, []interface{}{1, 2}},
+		{"entries(): キーと値の組", `entries({"a": 1, 2: "b"})`, []interface{}{[]interface{}{"a", 1}, []interface{}{2, "b"}}},
+		{"has(): キーがある", `has({"a": 1}, "a")`, true},
+		{"has(): キーがない", `has({"a": 1}, "b")`, false},
+		{"has(): エラー: ハッシュキーにできない", `has({"a": 1}, fn(x) { x })`, &object.Error{Message: "unusable as hash key: FUNCTION"}},
+		{"delete(): キーを取り除く", `entries(delete({"a": 1, "b": 2, "c": 3}, "b"))`, []interface{}{[]interface{}{"a", 1}, []interface{}{"c", 3}}},
+		{"delete(): 元のハッシュは変わらない", `let h = {"a": 1}; delete(h, "a"); keys(h)`, []interface{}{"a"}},
+		{"delete(): 存在しないキー", `keys(delete({"a": 1}, "z"))`, []interface{}{"a"}},
+		{"merge(): 後のハッシュで上書きする", `entries(merge({"a": 1, "b": 2}, {"b": 3, "c": 4}))`, []interface{}{[]interface{}{"a", 1}, []interface{}{"b", 3}, []interface{}{"c", 4}}},
+		{"merge(): エラー: ハッシュ以外", `merge({}, 1)`, &object.Error{Message: "argument `b` to `merge` must be HASH, got INTEGER"}},
+		{"len(): ハッシュのペアの数", `len({"a": 1, "b": 2})`, 2},
+		{"同じキーを書いたときは後の値で、順番は最初の位置", `entries({"a": 1, "b": 2, "a": 3})`, []interface{}{[]interface{}{"a", 3}, []interface{}{"b", 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated := testEval(tt.input)
+			testObject(t, evaluated, tt.expected)
+		})
+	}
+}
+
+// ハッシュの表示はいつも書いた順になる
+func TestHashInspectOrder(t *testing.T) {
+	input := `{"z": 1, "y": 2, "x": 3, 1: 4, true: 5}`
+
+	for i := 0; i < 20; i++ {
+		evaluated := testEval(input)
+		if evaluated.Inspect() != "{z: 1, y: 2, x: 3, 1: 4, true: 5}" {
+			t.Fatalf("wrong inspect. got=%q", evaluated.Inspect())
+		}
+	}
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"開始と終了", "[1, 2, 3, 4, 5][1:3]", []interface{}{2, 3}},
+		{"開始を省略", "[1, 2, 3, 4, 5][:2]", []interface{}{1, 2}},
+		{"終了を省略", "[1, 2, 3, 4, 5][3:]", []interface{}{4, 5}},
+		{"両方を省略するとコピー", "[1, 2, 3][:]", []interface{}{1, 2, 3}},
+		{"間隔", "[1, 2, 3, 4, 5][::2]", []interface{}{1, 3, 5}},
+		{"開始と終了と間隔", "[1, 2, 3, 4, 5][1:5:2]", []interface{}{2, 4}},
+		{"負の間隔で逆順", "[1, 2, 3][::-1]", []interface{}{3, 2, 1}},
+		{"負の間隔で開始と終了", "[1, 2, 3, 4, 5][3:0:-2]", []interface{}{4, 2}},
+		{"負の位置は末尾から数える", "[1, 2, 3, 4, 5][-2:]", []interface{}{4, 5}},
+		{"負の位置は末尾から数える", "[1, 2, 3, 4, 5][:-3]", []interface{}{1, 2}},
+		{"範囲外は切り詰める", "[1, 2, 3][1:100]", []interface{}{2, 3}},
+		{"範囲外は切り詰める", "[1, 2, 3][-100:1]", []interface{}{1}},
+		{"開始が終了より後なら空", "[1, 2, 3][2:1]", []interface{}{}},
+		{"位置に式を使える", "let a = [1, 2, 3, 4]; a[len(a) - 2:]", []interface{}{3, 4}},
+		{"文字列の添字アクセス", `"hello"[1]`, "e"},
+		{"文字列のマイナスの添字", `"hello"[-1]`, "o"},
+		{"文字列の範囲外の添字アクセスはNULL", `"hello"[5]`, nil},
+		{"文字列のスライス", `"hello"[1:4]`, "ell"},
+		{"文字列のスライスで逆順", `"hello"[::-1]`, "olleh"},
+		{"大きな間隔でもあふれない", "[1, 2, 3][1::9223372036854775807]", []interface{}{2}},
+		{"大きな間隔でもあふれない(文字列)", `"abc"[2::9223372036854775807]`, "c"},
+		{"大きな負の間隔でもあふれない", "[1, 2, 3][1::-9223372036854775807]", []interface{}{2}},
+		{"エラー: 間隔が0", "[1, 2, 3][::0]", &object.Error{Message: "slice step must not be 0"}},
+		{"エラー: 位置が整数以外", `[1, 2, 3]["a":]`, &object.Error{Message: "slice indices must be INTEGER, got STRING"}},
+		{"エラー: スライスできない型", `{"a": 1}[0:1]`, &object.Error{Message: "slice operator not supported: HASH"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated := testEval(tt.input)
+			testObject(t, evaluated, tt.expected)
+		})
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		strict   bool
+		expected interface{}
+	}{
+		{"配列の添字は整数", `[1, 2]["a"]`, false, &object.Error{Message: `ARRAY index must be INTEGER, got STRING ("a")`}},
+		{"配列の添字は整数", `[1, 2][true]`, false, &object.Error{Message: "ARRAY index must be INTEGER, got BOOLEAN (true)"}},
+		{"文字列の添字は整数", `"ab"[[0]]`, false, &object.Error{Message: "STRING index must be INTEGER, got ARRAY ([0])"}},
+		{"添字演算子に対応していない型", `1[0]`, false, &object.Error{Message: "index operator not supported: INTEGER"}},
+		{"ハッシュのキーに使えない値", `{}[fn(x) { x }]`, false, &object.Error{Message: "unusable as hash key: FUNCTION"}},
+
+		{"範囲外はNULL", `[1, 2][2]`, false, nil},
+		{"存在しないキーはNULL", `{"a": 1}["b"]`, false, nil},
+		{"strict: 範囲内はそのまま", `[1, 2][-2]`, true, 1},
+		{"strict: 配列の範囲外はエラー", `[1, 2][2]`, true, &object.Error{Message: "index out of range: 2 (ARRAY length 2)"}},
+		{"strict: 配列の負の範囲外はエラー", `[1, 2][-3]`, true, &object.Error{Message: "index out of range: -3 (ARRAY length 2)"}},
+		{"strict: 文字列の範囲外はエラー", `"ab"[5]`, true, &object.Error{Message: "index out of range: 5 (STRING length 2)"}},
+		{"strict: 存在しないキーはエラー", `{"a": 1}["b"]`, true, &object.Error{Message: `key not found: "b"`}},
+		{"strict: 関数の中でも有効", `let f = fn(a) { a[3] }; f([1])`, true, &object.Error{Message: "index out of range: 3 (ARRAY length 1)"}},
+		{"strict: スライスははみ出しても切り詰める", `[1, 2][1:10]`, true, []interface{}{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			env := object.NewEnvironment()
+			env.Settings().StrictIndex = tt.strict
+
+			testObject(t, Eval(program, env), tt.expected)
+		})
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+
+	for _, dir := range []string{filepath.Join(root, "data"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(root, "data", "a.txt"): "hello",
+		filepath.Join(root, "data", "b.txt"): "",
+		filepath.Join(outside, "secret.txt"): "secret",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "escape"):   outside,
+		filepath.Join(root, "inside"):   filepath.Join(root, "data"),
+		filepath.Join(root, "dangling"): filepath.Join(outside, "new.txt"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks are not supported: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"readFile(): 読み込む", `readFile("data/a.txt")`, "hello"},
+		{"readFile(): ルートの中の絶対パス", fmt.Sprintf(`readFile(%q)`, filepath.Join(root, "data", "a.txt")), "hello"},
+		{"readFile(): ルートの中を指すシンボリックリンク", `readFile("inside/a.txt")`, "hello"},
+		{"readFile(): エラー: 存在しない", `readFile("nothing.txt")`, &object.Error{Message: `readFile "nothing.txt": no such file or directory`}},
+		{"readFile(): エラー: .. でルートの外に出る", `readFile("../outside/secret.txt")`, &object.Error{Message: `readFile "../outside/secret.txt": path escapes the file root`}},
+		{"readFile(): エラー: ルートの外の絶対パス", fmt.Sprintf(`readFile(%q)`, filepath.Join(outside, "secret.txt")), &object.Error{Message: fmt.Sprintf(`readFile %q: path escapes the file root`, filepath.Join(outside, "secret.txt"))}},
+		{"readFile(): エラー: シンボリックリンクでルートの外に出る", `readFile("escape/secret.txt")`, &object.Error{Message: `readFile "escape/secret.txt": path escapes the file root`}},
+		{"writeFile(): 書いて読む", `writeFile("out.txt", "abc"); readFile("out.txt")`, "abc"},
+		{"writeFile(): 上書きする", `writeFile("out.txt", "abc"); writeFile("out.txt", "d"); readFile("out.txt")`, "d"},
+		{"writeFile(): エラー: シンボリックリンクでルートの外に出る", `writeFile("escape/new.txt", "x")`, &object.Error{Message: `writeFile "escape/new.txt": path escapes the file root`}},
+		{"writeFile(): エラー: リンク先のないシンボリックリンク", `writeFile("dangling", "x")`, &object.Error{Message: `writeFile "dangling": dangling symbolic link`}},
+		{"appendFile(): 書き足す", `appendFile("log.txt", "a"); appendFile("log.txt", "b"); readFile("log.txt")`, "ab"},
+		{"listDir(): 名前順", `listDir("data")`, []interface{}{"a.txt", "b.txt"}},
+		{"listDir(): ルートの中を指すシンボリックリンク", `listDir("inside")`, []interface{}{"a.txt", "b.txt"}},
+		{"listDir(): エラー: シンボリックリンクでルートの外に出る", `listDir("escape")`, &object.Error{Message: `listDir "escape": path escapes the file root`}},
+		{"exists(): ある", `exists("data/a.txt")`, true},
+		{"exists(): ない", `exists("data/z.txt")`, false},
+		{"exists(): エラー: ルートの外", `exists("../outside")`, &object.Error{Message: `exists "../outside": path escapes the file root`}},
+		{"removeFile(): 削除する", `writeFile("tmp.txt", ""); removeFile("tmp.txt"); exists("tmp.txt")`, false},
+		{"removeFile(): エラー: ルートそのもの", `removeFile(".")`, &object.Error{Message: `removeFile ".": cannot remove the root directory`}},
+		{"removeFile(): エラー: シンボリックリンクでルートの外に出る", `removeFile("escape/secret.txt")`, &object.Error{Message: `removeFile "escape/secret.txt": path escapes the file root`}},
+		{"エラー: 引数が文字列以外", `readFile(1)`, &object.Error{Message: "argument `path` to `readFile` must be STRING, got INTEGER"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			env := object.NewEnvironment()
+			env.Settings().FileRoot = root
+
+			testObject(t, Eval(program, env), tt.expected)
+
+			// ルートの外は変わっていない
+			if _, err := os.Stat(filepath.Join(outside, "new.txt")); err == nil {
+				t.Fatalf("a file was created outside the root")
+			}
+			if content, _ := ioutil.ReadFile(filepath.Join(outside, "secret.txt")); string(content) != "secret" {
+				t.Fatalf("a file outside the root was changed")
+			}
+		})
+	}
+}
+
+// removeFile はシンボリックリンクのリンク先ではなく、リンクそのものを削除する
+func TestRemoveSymlink(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+
+	for _, dir := range []string{root, outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	targets := []string{filepath.Join(root, "a.txt"), filepath.Join(outside, "secret.txt")}
+	for _, path := range targets {
+		if err := ioutil.WriteFile(path, []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "inside"):   targets[0],
+		filepath.Join(root, "escape"):   targets[1],
+		filepath.Join(root, "dangling"): filepath.Join(outside, "none.txt"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks are not supported: %s", err)
+		}
+	}
+
+	input := `removeFile("inside"); removeFile("escape"); removeFile("dangling"); listDir(".")`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	env := object.NewEnvironment()
+	env.Settings().FileRoot = root
+
+	testObject(t, Eval(program, env), []interface{}{"a.txt"})
+
+	for _, path := range targets {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("target of a symbolic link was removed: %s", err)
+		}
+	}
+}
+
+// ルートが設定されていなければファイルは扱えない
+func TestFileBuiltinsDisabled(t *testing.T) {
+	evaluated := testEval(`readFile("a.txt")`)
+	testObject(t, evaluated, &object.Error{Message: `readFile "a.txt": file access is disabled`})
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	// Monkeyの文字列リテラルには " を書けないので、JSON の文字列は src に束縛して渡す
+	tests := []struct {
+		name     string
+		src      string
+		input    string
+		expected interface{}
+	}{
+		{"jsonParse(): 整数", "42", `jsonParse(src)`, 42},
+		{"jsonParse(): 負の整数", "-7", `jsonParse(src)`, -7},
+		{"jsonParse(): 文字列", `"a \"b\"\n"`, `jsonParse(src)`, "a \"b\"\n"},
+		{"jsonParse(): 真偽値", "true", `jsonParse(src)`, true},
+		{"jsonParse(): null", "null", `jsonParse(src)`, nil},
+		{"jsonParse(): 配列", "[1, [2, 3], []]", `jsonParse(src)`, []interface{}{1, []interface{}{2, 3}, []interface{}{}}},
+		{"jsonParse(): オブジェクトは書かれた順", `{"b": 1, "a": {"c": 2}}`, `keys(jsonParse(src))`, []interface{}{"b", "a"}},
+		{"jsonParse(): 入れ子のオブジェクト", `{"a": {"c": [true]}}`, `jsonParse(src)["a"]["c"]`, []interface{}{true}},
+		{"jsonParse(): エラー: 小数", "1.5", `jsonParse(src)`, &object.Error{Message: "jsonParse: number 1.5 is not supported (only 64-bit integers are)"}},
+		{"jsonParse(): エラー: 壊れたJSON", "[1, ", `jsonParse(src)`, &object.Error{Message: "jsonParse: unexpected end of JSON input"}},
+		{"jsonParse(): エラー: 余計なもの", "1 2", `jsonParse(src)`, &object.Error{Message: "jsonParse: unexpected data after the top-level value"}},
+		{"jsonParse(): エラー: 空", "", `jsonParse(src)`, &object.Error{Message: "jsonParse: unexpected end of JSON input"}},
+
+		{"jsonStringify(): 基本の値", "", `jsonStringify([1, "a", true, false, if (false) { 1 }])`, `[1,"a",true,false,null]`},
+		{"jsonStringify(): キーは追加した順", "", `jsonStringify({"b": 1, "a": [2]})`, `{"b":1,"a":[2]}`},
+		{"jsonStringify(): 文字列のエスケープ", `say "<hi>"`, `jsonStringify(src)`, `"say \"<hi>\""`},
+		{"jsonStringify(): 整数で字下げ", "", `jsonStringify({"a": [1]}, 2)`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{"jsonStringify(): 文字列で字下げ", "", "jsonStringify([1], \"\t\")", "[\n\t1\n]"},
+		{"jsonStringify(): 空の配列とハッシュ", "", `jsonStringify([[], {}], 2)`, "[\n  [],\n  {}\n]"},
+		{"jsonStringify(): 往復", `{"z":[1,{"y":null}],"x":"v"}`, `jsonStringify(jsonParse(src)) == src`, true},
+		{"jsonStringify(): エラー: 関数", "", `jsonStringify({"f": fn(x) { x }})`, &object.Error{Message: "jsonStringify: FUNCTION cannot be converted to JSON"}},
+		{"jsonStringify(): エラー: 組み込み関数", "", `jsonStringify([len])`, &object.Error{Message: "jsonStringify: BUILTIN cannot be converted to JSON"}},
+		{"jsonStringify(): エラー: 文字列以外のキー", "", `jsonStringify({1: 2})`, &object.Error{Message: "jsonStringify: object keys must be STRING, got INTEGER"}},
+		{"jsonStringify(): エラー: 字下げが大きすぎる", "", `jsonStringify([1], 9223372036854775807)`, &object.Error{Message: "second argument to `jsonStringify` must be between 0 and 10, got 9223372036854775807"}},
+		{"jsonStringify(): エラー: 負の字下げ", "", `jsonStringify([1], -1)`, &object.Error{Message: "second argument to `jsonStringify` must be between 0 and 10, got -1"}},
+		{"jsonStringify(): エラー: 字下げの文字列が長すぎる", "", `jsonStringify([1], "           ")`, &object.Error{Message: "second argument to `jsonStringify` must be at most 10 bytes, got 11"}},
+		{"jsonStringify(): エラー: 字下げの型", "", `jsonStringify(1, true)`, &object.Error{Message: "second argument to `jsonStringify` must be INTEGER or STRING, got BOOLEAN"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			env := object.NewEnvironment()
+			env.Set("src", &object.String{Value: tt.src})
+
+			testObject(t, Eval(program, env), tt.expected)
+		})
+	}
+}
+
+// 字下げする前に見積もる長さは、json.Indent で字下げした長さと同じ
+func TestIndentedLength(t *testing.T) {
+	inputs := []string{
+		`1`,
+		`[]`,
+		`[1,"a",null]`,
+		`{"a":[1,{"b":[]}],"c":{}}`,
+		`["[{\"\\",":,"]`,
+		`[[[[[[1]]]]]]`,
+	}
+
+	for _, input := range inputs {
+		for _, indent := range []string{" ", "\t\t", "          "} {
+			var out bytes.Buffer
+			if err := json.Indent(&out, []byte(input), "", indent); err != nil {
+				t.Fatalf("%s: %s", input, err)
+			}
+
+			if got := indentedLength([]byte(input), len(indent)); got != out.Len() {
+				t.Errorf("%s with indent %q: want=%d, got=%d", input, indent, out.Len(), got)
+			}
+		}
+	}
+}
+
+func TestBuiltinRegistry(t *testing.T) {
+	greet := &object.Builtin{
+		Name: "greet",
+		Doc:  "greet someone",
+		Params: []object.BuiltinParam{
+			{Name: "name", Type: object.STRING_OBJ},
+			{Name: "times", Type: object.INTEGER_OBJ, Optional: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return &object.String{Value: "hello " + args[0].(*object.String).Value}
+		},
+	}
+	sum := &object.Builtin{
+		Name:   "sum",
+		Params: []object.BuiltinParam{{Name: "xs", Type: object.INTEGER_OBJ, Variadic: true}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			var total int64
+			for _, arg := range args {
+				total += arg.(*object.Integer).Value
+			}
+			return &object.Integer{Value: total}
+		},
+	}
+	fakeLen := &object.Builtin{
+		Name: "len",
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return &object.Integer{Value: -1}
+		},
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"追加した組み込み関数", `greet("monkey")`, "hello monkey"},
+		{"省略できる引数", `greet("monkey", 2)`, "hello monkey"},
+		{"可変長の引数", `sum(1, 2, 3)`, 6},
+		{"可変長の引数: なし", `sum()`, 0},
+		{"標準の組み込み関数を上書き", `len("abc")`, -1},
+		{"関数の中からも見える", `let f = fn() { greet("x") }; f()`, "hello x"},
+		{"束縛が組み込み関数より優先", `let greet = 1; greet`, 1},
+		{"隠した組み込み関数", `puts`, &object.Error{Message: "identifier not found: puts"}},
+		{"隠していない組み込み関数", `first([1])`, 1},
+		{"エラー: 引数が少ない", `greet()`, &object.Error{Message: "wrong number of arguments to `greet`. got=0, want=1 to 2"}},
+		{"エラー: 引数が多い", `greet("a", 1, 2)`, &object.Error{Message: "wrong number of arguments to `greet`. got=3, want=1 to 2"}},
+		{"エラー: 引数の型", `greet(1)`, &object.Error{Message: "argument `name` to `greet` must be STRING, got INTEGER"}},
+		{"エラー: 可変長の引数の型", `sum(1, "2")`, &object.Error{Message: "argument `xs` to `sum` must be INTEGER, got STRING"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			env := object.NewEnvironment()
+			env.Builtins().Register(greet)
+			env.Builtins().Register(sum)
+			env.Builtins().Register(fakeLen)
+			env.Builtins().Hide("puts")
+
+			testObject(t, Eval(program, env), tt.expected)
+		})
+	}
+
+	// 登録は環境ごとなので、ほかの環境には影響しない
+	testObject(t, testEval(`len("abc")`), 3)
+	testObject(t, testEval(`greet`), &object.Error{Message: "identifier not found: greet"})
+}
+
+func TestBuiltinsList(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Builtins().Register(&object.Builtin{Name: "aaa", Fn: func(env *object.Environment, args ...object.Object) object.Object { return NULL }})
+	env.Builtins().Hide("len")
+
+	var names []string
+	for _, b := range Builtins(env) {
+		names = append(names, b.Name)
+		if b.Name != "aaa" && b.Doc == "" {
+			t.Errorf("builtin %s has no doc", b.Name)
+		}
+		// 標準の組み込み関数は、引数の数と型を Params で確かめる
+		if b.Name != "aaa" && b.Params == nil {
+			t.Errorf("builtin %s has no params", b.Name)
+		}
+	}
+
+	if names[0] != "aaa" {
+		t.Errorf("registered builtin is not listed first: %v", names)
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i-1] >= names[i] {
+			t.Errorf("builtins are not sorted: %q >= %q", names[i-1], names[i])
+		}
+		if names[i] == "len" {
+			t.Errorf("hidden builtin is listed")
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	os.Setenv("MONKEY_TEST_CAPABILITY", "granted")
+	defer os.Unsetenv("MONKEY_TEST_CAPABILITY")
+
+	tests := []struct {
+		name     string
+		caps     object.Capabilities
+		input    string
+		expected interface{}
+	}{
+		{"権限の設定なしならすべて許す", nil, `getenv("MONKEY_TEST_CAPABILITY")`, "granted"},
+		{"env: 許す", object.NewCapabilities(object.CapEnv), `getenv("MONKEY_TEST_CAPABILITY")`, "granted"},
+		{"env: 設定されていない環境変数", object.NewCapabilities(object.CapEnv), `getenv("MONKEY_TEST_UNSET")`, nil},
+		{"env: 許さない", object.NewCapabilities(), `getenv("MONKEY_TEST_CAPABILITY")`, &object.Error{Message: "`getenv` requires the env capability, which is not granted"}},
+		{"clock: 許す", object.NewCapabilities(object.CapClock), `now() > 1600000000000`, true},
+		{"clock: 許さない", object.NewCapabilities(object.CapEnv), `now()`, &object.Error{Message: "`now` requires the clock capability, which is not granted"}},
+		{"random: 許す", object.NewCapabilities(object.CapRandom), `let r = random(3); if (r > -1) { r < 3 } else { false }`, true},
+		{"random: 範囲", object.NewCapabilities(object.CapRandom), `random(0)`, &object.Error{Message: "argument to `random` must be positive, got 0"}},
+		{"random: 許さない", object.NewCapabilities(), `random(3)`, &object.Error{Message: "`random` requires the random capability, which is not granted"}},
+		{"stdout: 許さない", object.NewCapabilities(), `puts("x")`, &object.Error{Message: "`puts` requires the stdout capability, which is not granted"}},
+		{"filesystem: 許さない", object.NewCapabilities(), `readFile("a.txt")`, &object.Error{Message: "`readFile` requires the filesystem capability, which is not granted"}},
+		{"権限のいらない組み込み関数", object.NewCapabilities(), `len("abc")`, 3},
+		{"ほかの組み込み関数から呼んでも同じ", object.NewCapabilities(), `map([1], puts)`, &object.Error{Message: "`puts` requires the stdout capability, which is not granted"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			env := object.NewEnvironment()
+			env.Settings().Capabilities = tt.caps
+
+			testObject(t, Eval(program, env), tt.expected)
+		})
+	}
+}
+
+func TestOutputBuiltins(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		stdout string
+		stderr string
+	}{
+		{"puts: 1つずつ改行する", `puts("a", 1, [true])`, "a\n1\n[true]\n", ""},
+		{"print: 改行しない", `print("a", 1); print("b")`, "a1b", ""},
+		{"eprint: 標準エラー出力", `eprint("oops", 2)`, "", "oops\n2\n"},
+		{"出力先を分ける", `puts("out"); eprint("err"); print("!")`, "out\n!", "err\n"},
+		{"関数の中から", `let f = fn(x) { puts(x) }; map([1, 2], f)`, "1\n2\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			var stdout, stderr strings.Builder
+			env := object.NewEnvironment()
+			env.Settings().Stdout = &stdout
+			env.Settings().Stderr = &stderr
+
+			if evaluated := Eval(program, env); isError(evaluated) {
+				t.Fatalf("unexpected error: %s", evaluated.Inspect())
+			}
+
+			if stdout.String() != tt.stdout {
+				t.Errorf("wrong stdout. want=%q, got=%q", tt.stdout, stdout.String())
+			}
+			if stderr.String() != tt.stderr {
+				t.Errorf("wrong stderr. want=%q, got=%q", tt.stderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestConcurrencyBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"spawn と wait", `let t = spawn(fn(a, b) { a + b }, 1, 2); wait(t)`, 3},
+		{"組み込み関数を spawn", `wait(spawn(len, "abc"))`, 3},
+		{"タスクのエラーは wait で受け取る", `wait(spawn(fn() { 1 + true }))`, &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{"複数のタスク", `let ts = map(range(5), fn(i) { spawn(fn() { i * i }) }); map(ts, wait)`, []interface{}{0, 1, 4, 9, 16}},
+		{"バッファなしのチャネル", `let ch = channel(); spawn(fn() { send(ch, 42) }); recv(ch)`, 42},
+		{"バッファつきのチャネル", `let ch = channel(2); send(ch, 1); send(ch, 2); [recv(ch), recv(ch)]`, []interface{}{1, 2}},
+		{"閉じたチャネルからは残りを受け取ってから null", `let ch = channel(1); send(ch, 1); close(ch); [recv(ch), recv(ch)]`, []interface{}{1, nil}},
+		{"ファンアウトして集める", `let ch = channel(); map(range(1, 4), fn(n) { spawn(fn() { send(ch, n * n) }) }); reduce(range(3), 0, fn(acc, i) { acc + recv(ch) })`, 14},
+		{"select: 受け取れるものを選ぶ", `let a = channel(1); let b = channel(1); send(b, "b"); select([a, b])`, []interface{}{1, "b"}},
+		{"select: 送る", `let a = channel(); let b = channel(1); select([a, [b, 5]])`, []interface{}{1, nil}},
+		{"select: 送ったものを受け取る", `let b = channel(1); select([[b, 5]]); recv(b)`, 5},
+		{"wait group", `let wg = waitGroup(); let ch = channel(3); wgAdd(wg, 3); map(range(3), fn(i) { spawn(fn() { send(ch, i); wgDone(wg) }) }); wait(wg); close(ch); sort([recv(ch), recv(ch), recv(ch)])`, []interface{}{0, 1, 2}},
+		{"wait group: 0 なら待たない", `wait(waitGroup())`, nil},
+		{"環境を複数のゴルーチンから読む", `let x = 10; let ts = map(range(20), fn(i) { spawn(fn() { let y = x + i; y }) }); reduce(map(ts, wait), 0, fn(a, b) { a + b })`, 390},
+		{"エラー: 閉じたチャネルに送る", `let ch = channel(1); close(ch); send(ch, 1)`, &object.Error{Message: "send on closed channel"}},
+		{"エラー: 大きすぎるチャネル", `channel(4611686018427387904)`, &object.Error{Message: "channel size is too large: 4611686018427387904 (max 1048576)"}},
+		{"エラー: 2回閉じる", `let ch = channel(); close(ch); close(ch)`, &object.Error{Message: "close: close of closed channel"}},
+		{"エラー: wgDone しすぎ", `wgDone(waitGroup())`, &object.Error{Message: "wgDone: negative wait group counter"}},
+		{"エラー: spawn に関数以外", `spawn(1)`, &object.Error{Message: "first argument to `spawn` must be FUNCTION or BUILTIN, got INTEGER"}},
+		{"エラー: wait に待てないもの", `wait(1)`, &object.Error{Message: "argument to `wait` must be TASK or WAIT_GROUP, got INTEGER"}},
+		{"エラー: recv にチャネル以外", `recv(1)`, &object.Error{Message: "argument `ch` to `recv` must be CHANNEL, got INTEGER"}},
+		{"エラー: select の形", `select([[1, 2]])`, &object.Error{Message: "case 0 of `select` must be [CHANNEL, value], got [1, 2]"}},
+		{"エラー: 空の select", `select([])`, &object.Error{Message: "argument to `select` must not be empty"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testObject(t, testEval(tt.input), tt.expected)
+		})
+	}
+}
+
+// 複数のゴルーチンから同時に import しても、モジュールは一度だけ評価される
+func TestConcurrentImport(t *testing.T) {
+	files := map[string]string{
+		"slow.monkey": `let wait = fn(n) { if (n > 0) { wait(n - 1) } }; wait(200); export let answer = 42;`,
+	}
+
+	input := `
+let ts = map(range(10), fn(i) { spawn(fn() { import "slow" }) });
+let ms = map(ts, wait);
+[all(ms, fn(m) { m == ms[0] }), ms[0]["answer"]]`
+
+	testObject(t, testEvalWithModules(t, files, input), []interface{}{true, 42})
+}
+
+// コンテキストのない環境で評価しても、評価し終えたあとにゴルーチンは残らない
+func TestEvalStopsGoroutines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"途中で使われなくなったジェネレータ", `let nat = fn*() { let loop = fn(i) { yield i; loop(i + 1) }; loop(0) }; map(range(1000), fn(i) { next(nat()) }); 1`},
+		{"変数に束縛したジェネレータ", `let nat = fn*() { let loop = fn(i) { yield i; loop(i + 1) }; loop(0) }; let gs = map(range(100), fn(i) { nat() }); map(gs, next); 1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			testObject(t, Eval(program, object.NewEnvironment()), 1)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > before {
+				if time.Now().After(deadline) {
+					t.Fatalf("goroutines leaked after Eval returned: before=%d, after=%d", before, runtime.NumGoroutine())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// 待っているあいだに評価が取り消されたら、待つのをやめる
+func TestConcurrencyInterrupt(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"recv", "recv(channel())"},
+		{"send", "send(channel(), 1)"},
+		{"select", "select([channel()])"},
+		{"wait", "wait(spawn(fn() { recv(channel()) }))"},
+		{"wait group", "let wg = waitGroup(); wgAdd(wg); wait(wg)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			env := object.NewEnvironment()
+			env.SetContext(ctx)
+
+			if evaluated := Eval(program, env); !IsInterrupted(evaluated) {
+				t.Errorf("evaluation was not interrupted. got=%T(%+v)", evaluated, evaluated)
+			}
+		})
+	}
+}
+
+func TestIterators(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"配列", `let it = iter([1, 2]); [next(it), next(it), next(it)]`, []interface{}{1, 2, nil}},
+		{"文字列", `collect(iter("abc"))`, []interface{}{"a", "b", "c"}},
+		{"ハッシュは [キー, 値] を追加した順に", `collect(iter({"b": 1, "a": 2}))`, []interface{}{[]interface{}{"b", 1}, []interface{}{"a", 2}}},
+		{"done", `let it = iter([1]); [done(it), next(it), done(it)]`, []interface{}{false, 1, true}},
+		{"空の配列", `done(iter([]))`, true},
+		{"反復子を iter に渡すとそのまま", `let it = iter([1, 2]); next(it); next(iter(it))`, 2},
+		{"作ったあとに足した要素はたどらない", `let a = [1]; let it = iter(a); let b = push(a, 2); collect(it)`, []interface{}{1}},
+		{"collect に配列を渡す", `collect([1, 2])`, []interface{}{1, 2}},
+		{"seq", `collect(seq(3))`, []interface{}{0, 1, 2}},
+		{"seq: 始まりと刻み", `collect(seq(1, 10, 3))`, []interface{}{1, 4, 7}},
+		{"seq: 逆向き", `collect(seq(3, 0, -1))`, []interface{}{3, 2, 1}},
+		{"seq: 空", `done(seq(5, 5))`, true},
+		{"seq: 終わりの近くで桁あふれしない", `collect(seq(9223372036854775805, 9223372036854775807, 5))`, []interface{}{9223372036854775805}},
+		{"seq は長くても配列を作らない", `let it = seq(1000000000000); next(it); next(it)`, 1},
+		{"reduce に反復子を渡す", `reduce(seq(1, 101), 0, fn(acc, x) { acc + x })`, 5050},
+		{"途中まで取り出した反復子を reduce に渡す", `let it = iter([1, 2, 3]); next(it); reduce(it, 0, fn(acc, x) { acc + x })`, 5},
+		{"エラー: iter に反復できないもの", `iter(1)`, &object.Error{Message: "argument to `iter` must be ARRAY, STRING, HASH or an iterator, got INTEGER"}},
+		{"エラー: next に反復子以外", `next([1])`, &object.Error{Message: "argument to `next` must be ITERATOR or GENERATOR, got ARRAY"}},
+		{"エラー: done に反復子以外", `done(1)`, &object.Error{Message: "argument to `done` must be ITERATOR or GENERATOR, got INTEGER"}},
+		{"エラー: seq の刻みが 0", `seq(1, 5, 0)`, &object.Error{Message: "step of `seq` must not be 0"}},
+		{"エラー: seq に整数以外", `seq("a")`, &object.Error{Message: "argument `start` to `seq` must be INTEGER, got STRING"}},
+		{"エラー: reduce に反復できないもの", `reduce(1, 0, fn(acc, x) { acc })`, &object.Error{Message: "first argument to `reduce` must be ARRAY or an iterator, got INTEGER"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testObject(t, testEval(tt.input), tt.expected)
+		})
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"yield した順に返す", `let g = fn*() { yield 1; yield 2; }; let it = g(); [next(it), next(it), next(it)]`, []interface{}{1, 2, nil}},
+		{"引数を受け取る", `let g = fn*(a, b) { yield a; yield b; }; collect(g("x", "y"))`, []interface{}{"x", "y"}},
+		{"呼び出しただけでは本体を評価しない", `let g = fn*() { yield 1 + true; }; let it = g(); 5`, 5},
+		{"done は本体を次の yield まで動かす", `let g = fn*() { yield 1; }; let it = g(); [done(it), done(it), next(it), done(it)]`, []interface{}{false, false, 1, true}},
+		{"return で終わる", `let g = fn*() { yield 1; return 0; yield 2; }; collect(g())`, []interface{}{1}},
+		{"呼び出した関数の yield", `let naturals = fn*() { let loop = fn(n) { yield n; loop(n + 1) }; loop(0) }; let it = naturals(); next(it); next(it); next(it)`, 2},
+		{"組み込み関数に渡した関数の yield", `let g = fn*(arr) { map(arr, fn(x) { yield x * x }) }; collect(g([1, 2, 3]))`, []interface{}{1, 4, 9}},
+		{"呼び出すたびに新しいジェネレータ", `let g = fn*() { yield 1; }; let a = g(); let b = g(); next(a); [next(a), next(b)]`, []interface{}{nil, 1}},
+		{"ジェネレータの中のジェネレータ", `let inner = fn*() { yield 1; yield 2; }; let outer = fn*() { let it = inner(); yield next(it) * 10; yield next(it) * 10; }; collect(outer())`, []interface{}{10, 20}},
+		{"reduce で少しずつ畳み込む", `let squares = fn*(n) { let loop = fn(i) { if (i < n) { yield i * i; loop(i + 1) } }; loop(0) }; reduce(squares(4), 0, fn(acc, x) { acc + x })`, 14},
+		{"本体のエラーは next で受け取る", `let g = fn*() { yield 1; 1 + true }; let it = g(); next(it); next(it)`, &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{"本体のエラーは collect で受け取る", `let g = fn*() { yield 1; 1 + true }; collect(g())`, &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{"エラー: ジェネレータの外の yield", `yield 1;`, &object.Error{Message: "yield outside generator"}},
+		{"エラー: ふつうの関数の yield", `let f = fn() { yield 1; }; f()`, &object.Error{Message: "yield outside generator"}},
+		{"エラー: spawn した関数から yield できない", `let g = fn*() { yield wait(spawn(fn() { yield 1; })) }; next(g())`, &object.Error{Message: "yield outside generator"}},
+		{"エラー: 本体の中で自分の next を呼ぶ", `let g = fn*() { yield next(it); }; let it = g(); next(it)`, &object.Error{Message: "generator is already running"}},
+		{"エラー: 引数の数", `let g = fn*(a) { yield a; }; g()`, &object.Error{Message: "wrong number of arguments. got=0, want=1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testObject(t, testEval(tt.input), tt.expected)
+		})
+	}
+}
+
+// 次の値を待っているあいだに評価が取り消されたら、ジェネレータの本体も止まる
+func TestGeneratorInterrupt(t *testing.T) {
+	input := `let g = fn*() { let loop = fn() { loop() }; loop() }; next(g())`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	env := object.NewEnvironment()
+	env.SetContext(ctx)
+
+	if evaluated := Eval(program, env); !IsInterrupted(evaluated) {
+		t.Errorf("evaluation was not interrupted. got=%T(%+v)", evaluated, evaluated)
+	}
+}
@@ -0,0 +1,291 @@
+package evaluator
+
+import (
+	"errors"
+	"go-monkey-shakyo/monkey/object"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ファイルを扱う組み込み関数
+// パスは Settings().FileRoot からの相対パスで、その外にはアクセスできない
+// 使うには filesystem の権限も必要
+var fileBuiltins = map[string]*object.Builtin{
+	"readFile": {
+		Doc:      "read a file under the file root",
+		Requires: object.CapFilesystem,
+		Params:   stringParams("path"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			path, err := sandboxPath(env, "readFile", strs[0])
+			if err != nil {
+				return err
+			}
+
+			content, readErr := os.ReadFile(path)
+			if readErr != nil {
+				return fileError("readFile", strs[0], readErr)
+			}
+
+			return &object.String{Value: string(content)}
+		},
+	},
+	// ファイルがなければ作り、あれば中身を置き換える
+	"writeFile": {
+		Doc:      "write a file under the file root",
+		Requires: object.CapFilesystem,
+		Params:   stringParams("path", "content"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			path, err := sandboxPath(env, "writeFile", strs[0])
+			if err != nil {
+				return err
+			}
+
+			if writeErr := os.WriteFile(path, []byte(strs[1]), 0644); writeErr != nil {
+				return fileError("writeFile", strs[0], writeErr)
+			}
+
+			return NULL
+		},
+	},
+	// ファイルがなければ作り、あれば末尾に書き足す
+	"appendFile": {
+		Doc:      "append to a file under the file root",
+		Requires: object.CapFilesystem,
+		Params:   stringParams("path", "content"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			path, err := sandboxPath(env, "appendFile", strs[0])
+			if err != nil {
+				return err
+			}
+
+			f, openErr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if openErr != nil {
+				return fileError("appendFile", strs[0], openErr)
+			}
+			defer f.Close()
+
+			if _, writeErr := f.WriteString(strs[1]); writeErr != nil {
+				return fileError("appendFile", strs[0], writeErr)
+			}
+
+			return NULL
+		},
+	},
+	// ディレクトリの中の名前を名前順に並べた配列
+	"listDir": {
+		Doc:      "names in a directory under the file root",
+		Requires: object.CapFilesystem,
+		Params:   stringParams("path"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			path, err := sandboxPath(env, "listDir", strs[0])
+			if err != nil {
+				return err
+			}
+
+			entries, readErr := os.ReadDir(path)
+			if readErr != nil {
+				return fileError("listDir", strs[0], readErr)
+			}
+
+			elements := []object.Object{}
+			for _, entry := range entries {
+				elements = append(elements, &object.String{Value: entry.Name()})
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	"exists": {
+		Doc:      "whether a path under the file root exists",
+		Requires: object.CapFilesystem,
+		Params:   stringParams("path"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			path, err := sandboxPath(env, "exists", strs[0])
+			if err != nil {
+				return err
+			}
+
+			_, statErr := os.Stat(path)
+			if errors.Is(statErr, fs.ErrNotExist) {
+				return FALSE
+			}
+			if statErr != nil {
+				return fileError("exists", strs[0], statErr)
+			}
+
+			return TRUE
+		},
+	},
+	// ファイルか空のディレクトリを削除する
+	"removeFile": {
+		Doc:      "remove a file or an empty directory under the file root",
+		Requires: object.CapFilesystem,
+		Params:   stringParams("path"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			// シンボリックリンクはリンク先ではなくリンクそのものを削除する
+			path, err := sandboxEntry(env, "removeFile", strs[0])
+			if err != nil {
+				return err
+			}
+
+			if root, _ := sandboxRoot(env); path == root {
+				return newError("removeFile %q: cannot remove the root directory", strs[0])
+			}
+
+			if removeErr := os.Remove(path); removeErr != nil {
+				return fileError("removeFile", strs[0], removeErr)
+			}
+
+			return NULL
+		},
+	},
+}
+
+// シンボリックリンクをたどった、ファイル操作のルートディレクトリの絶対パス
+func sandboxRoot(env *object.Environment) (string, error) {
+	root := env.Settings().FileRoot
+	if root == "" {
+		return "", errors.New("file access is disabled")
+	}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(abs)
+}
+
+// スクリプトが指定したパスを、ルートディレクトリの中の実際のパスにする
+// 相対パスはルートディレクトリから数える。絶対パスはルートディレクトリの中を指すものだけを受け付ける
+// 途中のシンボリックリンクもたどって、最終的にルートディレクトリの外に出るならエラーにする
+func sandboxPath(env *object.Environment, name, path string) (string, *object.Error) {
+	root, joined, err := sandboxJoin(env, name, path)
+	if err != nil {
+		return "", err
+	}
+
+	return sandboxResolve(root, joined, name, path)
+}
+
+// sandboxPath と同じだが、最後の要素はシンボリックリンクでもたどらず、親ディレクトリだけをたどる
+// リンクそのものを扱うときに使う
+func sandboxEntry(env *object.Environment, name, path string) (string, *object.Error) {
+	root, joined, err := sandboxJoin(env, name, path)
+	if err != nil {
+		return "", err
+	}
+
+	if joined == root {
+		return root, nil
+	}
+
+	parent, err := sandboxResolve(root, filepath.Dir(joined), name, path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(parent, filepath.Base(joined)), nil
+}
+
+// ルートディレクトリと、path をルートディレクトリにつなげた(シンボリックリンクはたどらない)絶対パス
+func sandboxJoin(env *object.Environment, name, path string) (string, string, *object.Error) {
+	root, err := sandboxRoot(env)
+	if err != nil {
+		return "", "", newError("%s %q: %s", name, path, err)
+	}
+
+	joined := path
+	if !filepath.IsAbs(joined) {
+		joined = filepath.Join(root, joined)
+	}
+	joined = filepath.Clean(joined)
+
+	if !isWithin(root, joined) {
+		return "", "", newError("%s %q: path escapes the file root", name, path)
+	}
+
+	return root, joined, nil
+}
+
+// joined のシンボリックリンクをたどり、ルートディレクトリの外に出ないことを確かめる
+// エラーにはスクリプトが指定した path を書く
+func sandboxResolve(root, joined, name, path string) (string, *object.Error) {
+	resolved, err := resolveExisting(joined)
+	if err != nil {
+		return "", fileError(name, path, err)
+	}
+
+	if !isWithin(root, resolved) {
+		return "", newError("%s %q: path escapes the file root", name, path)
+	}
+
+	return resolved, nil
+}
+
+var errDanglingSymlink = errors.New("dangling symbolic link")
+
+// パスのうち存在する部分のシンボリックリンクをたどる
+// まだ存在しない部分(これから作るファイルなど)はそのまま後ろにつなげる
+func resolveExisting(path string) (string, error) {
+	var rest []string
+
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+
+		// リンク先が存在しないシンボリックリンクは、書き込むとリンク先にファイルができてしまう
+		// どこを指しているかに関わらず受け付けない
+		if _, lstatErr := os.Lstat(path); lstatErr == nil {
+			return "", errDanglingSymlink
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// path が root かその中にあるか
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// OS のエラーを、ルートディレクトリの実際の場所を含まないメッセージにする
+func fileError(name, path string, err error) *object.Error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+
+	return newError("%s %q: %s", name, path, err)
+}
@@ -0,0 +1,116 @@
+package evaluator
+
+import (
+	"go-monkey-shakyo/monkey/object"
+)
+
+// ハッシュを扱う組み込み関数
+// push と同じく、ハッシュを変更する関数も元のハッシュは変えずに新しいハッシュを返す
+var hashBuiltins = map[string]*object.Builtin{
+	// keys({"a": 1, "b": 2}) => ["a", "b"]
+	"keys": {
+		Doc:    "keys of a hash in insertion order",
+		Params: []object.BuiltinParam{{Name: "hash", Type: object.HASH_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			hash := args[0].(*object.Hash)
+
+			elements := []object.Object{}
+			for _, pair := range hash.Pairs() {
+				elements = append(elements, pair.Key)
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// values({"a": 1, "b": 2}) => [1, 2]
+	"values": {
+		Doc:    "values of a hash in insertion order",
+		Params: []object.BuiltinParam{{Name: "hash", Type: object.HASH_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			hash := args[0].(*object.Hash)
+
+			elements := []object.Object{}
+			for _, pair := range hash.Pairs() {
+				elements = append(elements, pair.Value)
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// entries({"a": 1, "b": 2}) => [["a", 1], ["b", 2]]
+	"entries": {
+		Doc:    "[key, value] pairs of a hash in insertion order",
+		Params: []object.BuiltinParam{{Name: "hash", Type: object.HASH_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			hash := args[0].(*object.Hash)
+
+			elements := []object.Object{}
+			for _, pair := range hash.Pairs() {
+				elements = append(elements, &object.Array{Elements: []object.Object{pair.Key, pair.Value}})
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// has({"a": 1}, "a") => true
+	"has": {
+		Doc: "whether a hash has a key",
+		Params: []object.BuiltinParam{
+			{Name: "hash", Type: object.HASH_OBJ},
+			{Name: "key"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			hash := args[0].(*object.Hash)
+
+			key, ok := object.AsHashable(args[1])
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			_, ok = hash.Get(key)
+			return nativeBoolToBooleanObject(ok)
+		},
+	},
+	// delete({"a": 1, "b": 2}, "a") => {"b": 2}
+	// 存在しないキーを指定したときは、同じ中身のハッシュを返す
+	"delete": {
+		Doc: "new hash without a key",
+		Params: []object.BuiltinParam{
+			{Name: "hash", Type: object.HASH_OBJ},
+			{Name: "key"},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			hash := args[0].(*object.Hash)
+
+			key, ok := object.AsHashable(args[1])
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			newHash := hash.Copy()
+			newHash.Delete(key)
+
+			return newHash
+		},
+	},
+	// merge({"a": 1, "b": 2}, {"b": 3, "c": 4}) => {"a": 1, "b": 3, "c": 4}
+	// 同じキーは後のハッシュの値で上書きする
+	"merge": {
+		Doc: "new hash with the pairs of all hashes, later ones winning",
+		Params: []object.BuiltinParam{
+			{Name: "a", Type: object.HASH_OBJ},
+			{Name: "b", Type: object.HASH_OBJ},
+			{Name: "more", Type: object.HASH_OBJ, Variadic: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			merged := object.NewHash()
+			for _, arg := range args {
+				for _, pair := range arg.(*object.Hash).Pairs() {
+					merged.Set(pair.Key.(object.Hashable), pair.Value)
+				}
+			}
+
+			return merged
+		},
+	},
+}
@@ -0,0 +1,109 @@
+package evaluator
+
+import (
+	"go-monkey-shakyo/monkey/object"
+	"strconv"
+)
+
+// 添字演算式 left[index] を評価する
+//
+//	配列と文字列: 添字は整数で、負なら末尾から数える
+//	ハッシュ: 添字はハッシュのキーとして使える値
+//	モジュール: 添字は公開された名前の文字列
+//
+// 範囲外の添字や存在しないキーは NULL になる
+// Settings().StrictIndex が true のときはエラーにする
+func evalIndexExpression(left, index object.Object, env *object.Environment) object.Object {
+	strict := env.Settings().StrictIndex
+
+	switch left := left.(type) {
+	case *object.Array:
+		idx, err := integerIndex(left, index, len(left.Elements), strict)
+		if err != nil {
+			return err
+		}
+		if idx < 0 {
+			return NULL
+		}
+
+		return left.Elements[idx]
+	case *object.String:
+		idx, err := integerIndex(left, index, len(left.Value), strict)
+		if err != nil {
+			return err
+		}
+		if idx < 0 {
+			return NULL
+		}
+
+		return &object.String{Value: left.Value[idx : idx+1]}
+	case *object.Hash:
+		return evalHashIndexExpression(left, index, strict)
+	case *object.Module:
+		return evalModuleIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
+// 長さ length の配列や文字列に対する添字を、先頭からの位置にして返す
+// 範囲外なら、strict のときはエラーを、そうでなければ -1 を返す
+func integerIndex(left, index object.Object, length int, strict bool) (int64, *object.Error) {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return 0, newError("%s index must be INTEGER, got %s (%s)", left.Type(), index.Type(), inspectIndex(index))
+	}
+
+	idx, ok := resolveIndex(integer.Value, length)
+	if !ok {
+		if strict {
+			return 0, newError("index out of range: %d (%s length %d)", integer.Value, left.Type(), length)
+		}
+
+		return -1, nil
+	}
+
+	return idx, nil
+}
+
+// 負の添字は末尾から数える(-1 が最後の要素)
+// 範囲外なら ok は false
+func resolveIndex(idx int64, length int) (int64, bool) {
+	if idx < 0 {
+		idx += int64(length)
+	}
+
+	if idx < 0 || idx >= int64(length) {
+		return 0, false
+	}
+
+	return idx, true
+}
+
+func evalHashIndexExpression(hash *object.Hash, index object.Object, strict bool) object.Object {
+	key, ok := object.AsHashable(index)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hash.Get(key)
+	if !ok {
+		if strict {
+			return newError("key not found: %s", inspectIndex(index))
+		}
+
+		return NULL
+	}
+
+	return pair.Value
+}
+
+// エラーメッセージに添字の値を載せるときの表示
+// 文字列は引用符で囲んで、数値の添字と見分けがつくようにする
+func inspectIndex(index object.Object) string {
+	if str, ok := index.(*object.String); ok {
+		return strconv.Quote(str.Value)
+	}
+
+	return index.Inspect()
+}
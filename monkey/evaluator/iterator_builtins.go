@@ -0,0 +1,145 @@
+package evaluator
+
+import (
+	"go-monkey-shakyo/monkey/object"
+)
+
+// 値を1つずつ取り出す反復子と、ジェネレータを扱う組み込み関数
+//
+//	let naturals = fn*() { let loop = fn(n) { yield n; loop(n + 1) }; loop(0) };
+//	let it = naturals();
+//	next(it); // => 0
+//	next(it); // => 1
+//	reduce(seq(1, 101), 0, fn(acc, x) { acc + x }); // => 5050
+//
+// 反復子は値を求められるたびに1つずつ作るので、長い列でも配列を作らずに扱える
+var iteratorBuiltins = map[string]*object.Builtin{
+	// iter([1, 2]) / iter("ab") / iter({"a": 1}) => 反復子
+	// ハッシュの反復子は [キー, 値] を追加した順に返す。反復子を渡すとそのまま返す
+	"iter": {
+		Doc:    "iterator over the elements of an array, the characters of a string or the [key, value] pairs of a hash",
+		Params: []object.BuiltinParam{{Name: "x"}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			it, ok := toIterator(args[0])
+			if !ok {
+				return newError("argument to `iter` must be ARRAY, STRING, HASH or an iterator, got %s", args[0].Type())
+			}
+
+			return it
+		},
+	},
+	// next(it) => 次の値。取り出し終えていたら null
+	// ジェネレータの本体がエラーで終わったら、そのエラーになる
+	"next": {
+		Doc:    "next value of an iterator, or null once it is exhausted",
+		Params: []object.BuiltinParam{{Name: "it"}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			it, ok := args[0].(object.Iterator)
+			if !ok {
+				return newError("argument to `next` must be ITERATOR or GENERATOR, got %s", args[0].Type())
+			}
+
+			value, ok := it.Next()
+			if !ok {
+				return NULL
+			}
+
+			return value
+		},
+	},
+	// done(it) => もう値が取り出せなければ true
+	// ジェネレータは、次の値があるかを確かめるために本体を次の yield まで動かす
+	"done": {
+		Doc:    "whether an iterator has no more values",
+		Params: []object.BuiltinParam{{Name: "it"}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			it, ok := args[0].(object.Iterator)
+			if !ok {
+				return newError("argument to `done` must be ITERATOR or GENERATOR, got %s", args[0].Type())
+			}
+
+			return nativeBoolToBooleanObject(it.Done())
+		},
+	},
+	// seq(3) => 0, 1, 2 を返す反復子
+	// seq(1, 10, 3) => 1, 4, 7 を返す反復子
+	// range と同じ引数で、配列を作らずに1つずつ返す
+	"seq": {
+		Doc:    "iterator over integers from start (default 0) to end with an optional step, like range without building an array",
+		Params: rangeParams,
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			start, end, step, err := rangeArgs("seq", args)
+			if err != nil {
+				return err
+			}
+
+			return object.NewRangeIterator(start, end, step)
+		},
+	},
+	// collect(it) => 残りの値をすべて集めた配列
+	"collect": {
+		Doc:    "array of the remaining values of an iterator",
+		Params: []object.BuiltinParam{{Name: "it"}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			it, ok := toIterator(args[0])
+			if !ok {
+				return newError("argument to `collect` must be ARRAY, STRING, HASH or an iterator, got %s", args[0].Type())
+			}
+
+			elements := []object.Object{}
+			for {
+				if err := checkInterrupted(env); err != nil {
+					return err
+				}
+
+				value, ok := it.Next()
+				if !ok {
+					return &object.Array{Elements: elements}
+				}
+				if isError(value) {
+					return value
+				}
+
+				// 終わらない反復子でも止まるように、集めながら大きさを確かめる
+				elements = append(elements, value)
+				if err := checkSize(env, object.ARRAY_OBJ, len(elements), sizeOf(&object.Array{Elements: elements})); err != nil {
+					return err
+				}
+			}
+		},
+	},
+}
+
+// 配列・文字列・ハッシュはそれをたどる新しい反復子に、反復子はそのままにする
+func toIterator(obj object.Object) (object.Iterator, bool) {
+	switch obj := obj.(type) {
+	case object.Iterator:
+		return obj, true
+	case *object.Array:
+		return object.NewArrayIterator(obj), true
+	case *object.String:
+		return object.NewStringIterator(obj), true
+	case *object.Hash:
+		return object.NewHashIterator(obj), true
+	default:
+		return nil, false
+	}
+}
+
+// fn* で定義した関数を呼び出したときに返すジェネレータ
+// 本体は env で評価し、本体の yield 文(と、そこから呼び出した関数の yield 文)で値を返す
+// ジェネレータを作った評価が終わると、本体は止まっている yield 文でエラーになって終わる
+func newGenerator(fn *object.Function, env *object.Environment) *object.Generator {
+	return object.NewGenerator(env.Done(), func(yield func(object.Object) bool) (result object.Object) {
+		// 評価器のバグで panic しても、プロセスごと落とさずにジェネレータのエラーにする
+		defer func() {
+			if r := recover(); r != nil {
+				result = newError("generator: panic: %v", r)
+			}
+		}()
+
+		env.SetYield(yield)
+
+		return unwrapReturnValue(Eval(fn.Body, env))
+	})
+}
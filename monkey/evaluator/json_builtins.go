@@ -0,0 +1,283 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go-monkey-shakyo/monkey/object"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSON を扱う組み込み関数
+//
+//	JSON       Monkey
+//	object  <-> HASH(キーは STRING)
+//	array   <-> ARRAY
+//	string  <-> STRING
+//	number  <-> INTEGER(Monkey には小数がないので、整数だけ)
+//	true    <-> BOOLEAN
+//	null    <-> NULL
+var jsonBuiltins = map[string]*object.Builtin{
+	// jsonParse(`{"a": [1, true]}`) => {a: [1, true]}
+	// オブジェクトのキーは JSON に書かれた順になる
+	"jsonParse": {
+		Doc:    "parse JSON into Monkey values",
+		Params: stringParams("json"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			dec := json.NewDecoder(strings.NewReader(args[0].(*object.String).Value))
+			dec.UseNumber()
+
+			value, parseErr := decodeJSON(dec)
+			if parseErr != nil {
+				return newError("jsonParse: %s", parseErr)
+			}
+
+			// 値のあとに余計なものが続いていないか
+			if _, tokErr := dec.Token(); tokErr != io.EOF {
+				return newError("jsonParse: unexpected data after the top-level value")
+			}
+
+			return value
+		},
+	},
+	// jsonStringify({"a": [1, true]}) => `{"a":[1,true]}`
+	// 2つ目の引数に整数を渡すと、その数の空白で字下げする。文字列を渡すとそれで字下げする
+	// ハッシュのキーは追加した順に書き出すので、同じ値からはいつも同じ文字列ができる
+	"jsonStringify": {
+		Doc: "convert a value to JSON with an optional indent",
+		Params: []object.BuiltinParam{
+			{Name: "value"},
+			{Name: "indent", Optional: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+
+			indent := ""
+			if len(args) == 2 {
+				switch arg := args[1].(type) {
+				case *object.Integer:
+					// JavaScript の JSON.stringify と同じく、字下げは 10 文字まで
+					if arg.Value < 0 || arg.Value > maxJSONIndent {
+						return newError("second argument to `jsonStringify` must be between 0 and %d, got %d", maxJSONIndent, arg.Value)
+					}
+					indent = strings.Repeat(" ", int(arg.Value))
+				case *object.String:
+					if len(arg.Value) > maxJSONIndent {
+						return newError("second argument to `jsonStringify` must be at most %d bytes, got %d", maxJSONIndent, len(arg.Value))
+					}
+					indent = arg.Value
+				default:
+					return newError("second argument to `jsonStringify` must be INTEGER or STRING, got %s", args[1].Type())
+				}
+			}
+
+			var out bytes.Buffer
+			if err := encodeJSON(&out, args[0]); err != nil {
+				return err
+			}
+
+			if indent == "" {
+				return &object.String{Value: out.String()}
+			}
+
+			// 字下げすると深い入れ子ほど長くなるので、字下げする前に長さを確かめる
+			if err := checkStringSize(env, "jsonStringify", indentedLength(out.Bytes(), len(indent))); err != nil {
+				return err
+			}
+
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, out.Bytes(), "", indent); err != nil {
+				return newError("jsonStringify: %s", err)
+			}
+
+			return &object.String{Value: indented.String()}
+		},
+	},
+}
+
+// jsonStringify で字下げに使える空白の数(文字列ならバイト数)の上限
+const maxJSONIndent = 10
+
+// 空白を含まない JSON の src を、1段あたり indent バイトで json.Indent したときの長さ
+// json.Indent と同じく、空の配列とオブジェクトは改行せず、: のあとに空白を1つ入れる
+func indentedLength(src []byte, indent int) int {
+	length, depth := 0, 0
+	inString, escaped, needIndent := false, false, false
+
+	newline := func() {
+		length = addSize(length, addSize(1, mulSize(depth, indent)))
+	}
+
+	for _, c := range src {
+		length = addSize(length, 1)
+
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		if needIndent && c != ']' && c != '}' {
+			needIndent = false
+			depth++
+			newline()
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '[', '{':
+			needIndent = true
+		case ',':
+			newline()
+		case ':':
+			length = addSize(length, 1)
+		case ']', '}':
+			if needIndent {
+				needIndent = false
+			} else {
+				depth--
+				newline()
+			}
+		}
+	}
+
+	return length
+}
+
+// JSON の値を1つ読んで Monkey のオブジェクトにする
+func decodeJSON(dec *json.Decoder) (object.Object, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, errors.New("unexpected end of JSON input")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch tok {
+		case '[':
+			elements := []object.Object{}
+			for dec.More() {
+				el, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, el)
+			}
+
+			// 閉じ括弧 ']' を読み飛ばす
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+
+			return &object.Array{Elements: elements}, nil
+		case '{':
+			hash := object.NewHash()
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				value, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+
+				hash.Set(&object.String{Value: keyTok.(string)}, value)
+			}
+
+			// 閉じ括弧 '}' を読み飛ばす
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+
+			return hash, nil
+		default:
+			return nil, errors.New("unexpected " + tok.String())
+		}
+	case json.Number:
+		n, err := strconv.ParseInt(tok.String(), 10, 64)
+		if err != nil {
+			return nil, errors.New("number " + tok.String() + " is not supported (only 64-bit integers are)")
+		}
+
+		return &object.Integer{Value: n}, nil
+	case string:
+		return &object.String{Value: tok}, nil
+	case bool:
+		return nativeBoolToBooleanObject(tok), nil
+	case nil:
+		return NULL, nil
+	default:
+		return nil, errors.New("unexpected JSON token")
+	}
+}
+
+// obj を字下げなしの JSON にして out に書き出す
+func encodeJSON(out *bytes.Buffer, obj object.Object) *object.Error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		out.WriteString(strconv.FormatInt(obj.Value, 10))
+	case *object.Boolean:
+		out.WriteString(strconv.FormatBool(obj.Value))
+	case *object.NULL:
+		out.WriteString("null")
+	case *object.String:
+		writeJSONString(out, obj.Value)
+	case *object.Array:
+		out.WriteByte('[')
+		for i, el := range obj.Elements {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := encodeJSON(out, el); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case *object.Hash:
+		out.WriteByte('{')
+		for i, pair := range obj.Pairs() {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return newError("jsonStringify: object keys must be STRING, got %s", pair.Key.Type())
+			}
+
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			writeJSONString(out, key.Value)
+			out.WriteByte(':')
+			if err := encodeJSON(out, pair.Value); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	default:
+		return newError("jsonStringify: %s cannot be converted to JSON", obj.Type())
+	}
+
+	return nil
+}
+
+// 文字列を JSON の文字列リテラルにする
+// < や > も読みやすいようにそのまま書き出す(json.Marshal はエスケープしてしまう)
+func writeJSONString(out *bytes.Buffer, s string) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+
+	out.Write(bytes.TrimRight(buf.Bytes(), "\n"))
+}
@@ -0,0 +1,98 @@
+package evaluator
+
+import (
+	"fmt"
+	"go-monkey-shakyo/monkey/object"
+)
+
+// 資源の上限を超えたときのエラー
+func exhausted(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Err: object.ErrResourceExhausted}
+}
+
+// 長さ length、おおよそ bytes バイトの t の値を作ってよいか
+// 作ってから確かめると間に合わないとき(range や repeat で大きな値を作るとき)は、作る前にこれを呼ぶ
+// 使った量に足すのは、作った値を allocate に渡したとき
+func checkSize(env *object.Environment, t object.ObjectType, length, bytes int) *object.Error {
+	if max := env.Limits().MaxLength; max != 0 && length > max {
+		return exhausted("length limit exceeded: %s of length %d (max %d)", t, length, max)
+	}
+
+	if !env.CanAllocate(bytes) {
+		return exhausted("memory limit exceeded (max %d bytes)", env.Limits().MaxAlloc)
+	}
+
+	return nil
+}
+
+// 作る前に見積もるバイト数の掛け算と足し算
+// int に収まらないときは maxInt にするので、checkSize に渡せば必ず上限を超える
+func mulSize(n, size int) int {
+	if n != 0 && size > maxInt/n {
+		return maxInt
+	}
+
+	return n * size
+}
+
+func addSize(a, b int) int {
+	if a > maxInt-b {
+		return maxInt
+	}
+
+	return a + b
+}
+
+// 新しく作った値の大きさを確かめて、使った量に足す
+// 上限を超えたらエラーを、超えなければ obj をそのまま返す
+func allocate(env *object.Environment, obj object.Object) object.Object {
+	if obj == nil {
+		return nil
+	}
+
+	var length int
+	switch obj := obj.(type) {
+	case *object.String:
+		length = len(obj.Value)
+	case *object.Array:
+		length = len(obj.Elements)
+	case *object.Hash:
+		length = obj.Len()
+	}
+
+	size := sizeOf(obj)
+	if err := checkSize(env, obj.Type(), length, size); err != nil {
+		return err
+	}
+
+	if !env.Allocate(size) {
+		return exhausted("memory limit exceeded (max %d bytes)", env.Limits().MaxAlloc)
+	}
+
+	return obj
+}
+
+// 値のおおよそのバイト数
+// 配列やハッシュの要素はそれぞれ作ったときに数えているので、ここでは要素への参照の分だけ数える
+func sizeOf(obj object.Object) int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return 8
+	case *object.String:
+		return 16 + len(obj.Value)
+	case *object.Array:
+		return 24 + 16*len(obj.Elements)
+	case *object.Hash:
+		return 48 + 64*obj.Len()
+	case *object.Function:
+		return 48
+	default:
+		// TRUE・FALSE・NULL は使いまわしているので数えない
+		return 0
+	}
+}
+
+// 関数を呼び出すときに作る環境のおおよそのバイト数
+func envSize(params int) int {
+	return 64 + 32*params
+}
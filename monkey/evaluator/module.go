@@ -0,0 +1,211 @@
+package evaluator
+
+import (
+	"errors"
+	"go-monkey-shakyo/monkey/ast"
+	"go-monkey-shakyo/monkey/lexer"
+	"go-monkey-shakyo/monkey/object"
+	"go-monkey-shakyo/monkey/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// モジュールのファイルの拡張子(import のパスで省略できる)
+const ModuleExtension = ".monkey"
+
+// import "path" を評価する
+// モジュールは一度だけ評価して登録簿にキャッシュし、2回目以降は同じモジュールを返す
+func evalImportExpression(node *ast.ImportExpression, env *object.Environment) object.Object {
+	if !env.Settings().Allows(object.CapModules) {
+		return newError("`import` requires the %s capability, which is not granted", object.CapModules)
+	}
+
+	if filepath.IsAbs(node.Path) {
+		return newError("import path must be relative: %q", node.Path)
+	}
+
+	registry := env.Modules()
+
+	path, err := resolveModule(node.Path, env.Dir(), env.ModuleRoot(), registry.SearchPath)
+	if errors.Is(err, errModuleEscapes) {
+		return newError("import %q: path escapes the module path", node.Path)
+	}
+	if err != nil {
+		return newError("module not found: %q", node.Path)
+	}
+
+	if module, ok := registry.Get(path); ok {
+		return module
+	}
+
+	// 自分が読み込み中のモジュールをもう一度 import したら循環している
+	for i, p := range env.Importing() {
+		if p == path {
+			cycle := append(append([]string{}, env.Importing()[i:]...), path)
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	// ほかのゴルーチンが読み込み中なら、読み込み終えるのを待つ
+	for {
+		module, wait := registry.Begin(path)
+		if module != nil {
+			return module
+		}
+		if wait == nil {
+			break
+		}
+
+		select {
+		case <-wait:
+		case <-env.Done():
+			return checkInterrupted(env)
+		}
+	}
+
+	module := loadModule(node, path, env)
+	if isError(module) {
+		registry.End(path, nil)
+		return module
+	}
+
+	registry.End(path, module.(*object.Module))
+	return module
+}
+
+// path のモジュールを読み込んで評価する
+func loadModule(node *ast.ImportExpression, path string, env *object.Environment) object.Object {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return newError("could not read module %q: %s", node.Path, err)
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		return newError("parse error in module %q: %s", node.Path, p.Diagnostics()[0])
+	}
+
+	// モジュールは自分だけの環境で評価する
+	moduleEnv := object.NewModuleEnvironment(env, path)
+
+	result := Eval(program, moduleEnv)
+	if isError(result) {
+		return result
+	}
+
+	module := &object.Module{Path: path, Exports: make(map[string]object.Object)}
+
+	// トップレベルで export された束縛だけを公開する
+	for _, statement := range program.Statements {
+		if export, ok := statement.(*ast.ExportStatement); ok {
+			name := export.Statement.Name.Value
+			if val, ok := moduleEnv.Get(name); ok {
+				module.Exports[name] = val
+			}
+		}
+	}
+
+	return module
+}
+
+var (
+	errModuleNotFound = errors.New("module not found")
+	errModuleEscapes  = errors.New("path escapes the module path")
+)
+
+// import のパスを、シンボリックリンクをたどったファイルの絶対パスにする
+// "./" か "../" で始まるパスは import する側のディレクトリからだけ探し、
+// それ以外は import する側のディレクトリ、検索パスの順に探す
+// 起点のディレクトリ root と検索パスの外にあるファイルは、見つかっても読み込まない
+func resolveModule(name, dir, root string, searchPath []string) (string, error) {
+	if filepath.Ext(name) == "" {
+		name += ModuleExtension
+	}
+
+	var candidates []string
+
+	switch {
+	case strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../"):
+		candidates = []string{filepath.Join(dir, name)}
+	default:
+		candidates = []string{filepath.Join(dir, name)}
+		for _, base := range searchPath {
+			candidates = append(candidates, filepath.Join(base, name))
+		}
+	}
+
+	var allowed []string
+	for _, base := range append([]string{root}, searchPath...) {
+		if resolved, err := resolveDir(base); err == nil {
+			allowed = append(allowed, resolved)
+		}
+	}
+
+	escaped := false
+
+	for _, candidate := range candidates {
+		abs, err := filepath.Abs(candidate)
+		if err != nil {
+			continue
+		}
+
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			continue
+		}
+
+		if !withinAny(allowed, resolved) {
+			escaped = true
+			continue
+		}
+
+		if info, err := os.Stat(resolved); err != nil || info.IsDir() {
+			continue
+		}
+
+		return resolved, nil
+	}
+
+	if escaped {
+		return "", errModuleEscapes
+	}
+
+	return "", errModuleNotFound
+}
+
+// ディレクトリの絶対パスを、シンボリックリンクをたどって求める
+func resolveDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(abs)
+}
+
+func withinAny(roots []string, path string) bool {
+	for _, root := range roots {
+		if isWithin(root, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func evalModuleIndexExpression(moduleObject *object.Module, index object.Object) object.Object {
+	name, ok := index.(*object.String)
+	if !ok {
+		return newError("module index must be STRING, got %s", index.Type())
+	}
+
+	val, ok := moduleObject.Exports[name.Value]
+	if !ok {
+		return newError("module %s does not export %s", moduleObject.Path, name.Value)
+	}
+
+	return val
+}
@@ -0,0 +1,126 @@
+package evaluator
+
+import (
+	"go-monkey-shakyo/monkey/ast"
+	"go-monkey-shakyo/monkey/object"
+)
+
+// a[start:end:step] を評価する
+// 配列なら配列を、文字列なら文字列を返す
+// 範囲からはみ出した位置は切り詰めるので、スライスはエラーにならない
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	// 省略された位置は nil のまま
+	var bounds [3]*int64
+	for i, exp := range []ast.Expression{node.Start, node.End, node.Step} {
+		if exp == nil {
+			continue
+		}
+
+		obj := Eval(exp, env)
+		if isError(obj) {
+			return obj
+		}
+
+		integer, ok := obj.(*object.Integer)
+		if !ok {
+			return newError("slice indices must be INTEGER, got %s", obj.Type())
+		}
+
+		bounds[i] = &integer.Value
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		indices, err := sliceIndices(len(left.Elements), bounds[0], bounds[1], bounds[2])
+		if err != nil {
+			return err
+		}
+
+		elements := make([]object.Object, 0, len(indices))
+		for _, i := range indices {
+			elements = append(elements, left.Elements[i])
+		}
+
+		return &object.Array{Elements: elements}
+	case *object.String:
+		indices, err := sliceIndices(len(left.Value), bounds[0], bounds[1], bounds[2])
+		if err != nil {
+			return err
+		}
+
+		out := make([]byte, 0, len(indices))
+		for _, i := range indices {
+			out = append(out, left.Value[i])
+		}
+
+		return &object.String{Value: string(out)}
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+}
+
+// 長さ length の列から取り出す位置を順に並べる(Python のスライスと同じ規則)
+//
+//	step > 0: start の既定値は 0、end の既定値は length
+//	step < 0: start の既定値は最後の位置、end の既定値は先頭の手前
+//
+// 負の位置は末尾から数え、はみ出した位置は列の端に切り詰める
+func sliceIndices(length int, start, end, step *int64) ([]int, *object.Error) {
+	n := int64(length)
+
+	s := int64(1)
+	if step != nil {
+		s = *step
+	}
+
+	if s == 0 {
+		return nil, newError("slice step must not be 0")
+	}
+
+	// 切り詰める範囲。step が負のときは先頭の手前(-1)まで進める
+	lower, upper := int64(0), n
+	if s < 0 {
+		lower, upper = -1, n-1
+	}
+
+	bound := func(p *int64, def int64) int64 {
+		if p == nil {
+			return def
+		}
+
+		v := *p
+		if v < 0 {
+			v += n
+		}
+
+		if v < lower {
+			return lower
+		}
+		if v > upper {
+			return upper
+		}
+
+		return v
+	}
+
+	var from, to int64
+	if s > 0 {
+		from, to = bound(start, 0), bound(end, n)
+	} else {
+		from, to = bound(start, n-1), bound(end, -1)
+	}
+
+	// step が大きいと i += s があふれるので、位置の数を先に数えてその数だけ進める
+	count := rangeLength(from, to, s)
+	indices := make([]int, 0, count)
+	for i, k := from, 0; k < count; i, k = i+s, k+1 {
+		indices = append(indices, int(i))
+	}
+
+	return indices, nil
+}
@@ -0,0 +1,305 @@
+package evaluator
+
+import (
+	"bytes"
+	"go-monkey-shakyo/monkey/object"
+	"strings"
+)
+
+// 文字列を扱う組み込み関数
+var stringBuiltins = map[string]*object.Builtin{
+	// split("a,b,c", ",") => ["a", "b", "c"]
+	"split": {
+		Doc:    "split a string by a separator into an array of strings",
+		Params: stringParams("s", "sep"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			var elements []object.Object
+			for _, s := range strings.Split(strs[0], strs[1]) {
+				elements = append(elements, &object.String{Value: s})
+			}
+
+			return &object.Array{Elements: elements}
+		},
+	},
+	// join(["a", "b", "c"], ",") => "a,b,c"
+	"join": {
+		Doc: "join an array of strings with a separator",
+		Params: []object.BuiltinParam{
+			{Name: "arr", Type: object.ARRAY_OBJ},
+			{Name: "sep", Type: object.STRING_OBJ},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			sep := args[1].(*object.String)
+
+			var strs []string
+			for i, el := range args[0].(*object.Array).Elements {
+				s, ok := el.(*object.String)
+				if !ok {
+					return newError("elements of the array passed to `join` must be STRING, got %s at index %d", el.Type(), i)
+				}
+
+				strs = append(strs, s.Value)
+			}
+
+			// 大きすぎる文字列は作る前に断る
+			length := 0
+			for i, s := range strs {
+				if i > 0 {
+					length = addSize(length, len(sep.Value))
+				}
+				length = addSize(length, len(s))
+			}
+			if err := checkStringSize(env, "join", length); err != nil {
+				return err
+			}
+
+			return &object.String{Value: strings.Join(strs, sep.Value)}
+		},
+	},
+	"trim": {
+		Doc:    "remove leading and trailing whitespace",
+		Params: stringParams("s"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			return &object.String{Value: strings.TrimSpace(strs[0])}
+		},
+	},
+	"upper": {
+		Doc:    "convert a string to upper case",
+		Params: stringParams("s"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			return &object.String{Value: strings.ToUpper(strs[0])}
+		},
+	},
+	"lower": {
+		Doc:    "convert a string to lower case",
+		Params: stringParams("s"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			return &object.String{Value: strings.ToLower(strs[0])}
+		},
+	},
+	// replace("aaa", "a", "b") => "bbb"(すべて置き換える)
+	"replace": {
+		Doc:    "replace all occurrences of a substring",
+		Params: stringParams("s", "old", "new"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			// 大きすぎる文字列は作る前に断る(短くなるときは確かめなくてよい)
+			if growth := len(strs[2]) - len(strs[1]); growth > 0 {
+				length := addSize(len(strs[0]), mulSize(strings.Count(strs[0], strs[1]), growth))
+				if err := checkStringSize(env, "replace", length); err != nil {
+					return err
+				}
+			}
+
+			return &object.String{Value: strings.Replace(strs[0], strs[1], strs[2], -1)}
+		},
+	},
+	"contains": {
+		Doc:    "whether a string contains a substring",
+		Params: stringParams("s", "substr"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			return nativeBoolToBooleanObject(strings.Contains(strs[0], strs[1]))
+		},
+	},
+	"startsWith": {
+		Doc:    "whether a string starts with a prefix",
+		Params: stringParams("s", "prefix"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			return nativeBoolToBooleanObject(strings.HasPrefix(strs[0], strs[1]))
+		},
+	},
+	"endsWith": {
+		Doc:    "whether a string ends with a suffix",
+		Params: stringParams("s", "suffix"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			return nativeBoolToBooleanObject(strings.HasSuffix(strs[0], strs[1]))
+		},
+	},
+	// 見つからなければ -1
+	"indexOf": {
+		Doc:    "position of the first occurrence of a substring, or -1",
+		Params: stringParams("s", "substr"),
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+
+			return &object.Integer{Value: int64(strings.Index(strs[0], strs[1]))}
+		},
+	},
+	// substr(s, start) または substr(s, start, length)
+	// 範囲が文字列からはみ出す分は切り詰める
+	"substr": {
+		Doc: "substring from a start position with an optional length",
+		Params: []object.BuiltinParam{
+			{Name: "s", Type: object.STRING_OBJ},
+			{Name: "start", Type: object.INTEGER_OBJ},
+			{Name: "length", Type: object.INTEGER_OBJ, Optional: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			str, start := args[0].(*object.String), args[1].(*object.Integer)
+
+			length := int64(len(str.Value))
+			if len(args) == 3 {
+				length = args[2].(*object.Integer).Value
+			}
+
+			if start.Value < 0 || length < 0 {
+				return newError("arguments to `substr` must not be negative, got start=%d, length=%d", start.Value, length)
+			}
+
+			// from+length があふれないように、残りの長さで切り詰めてから足す
+			from := clamp(start.Value, int64(len(str.Value)))
+			to := from + clamp(length, int64(len(str.Value))-from)
+
+			return &object.String{Value: str.Value[from:to]}
+		},
+	},
+	"repeat": {
+		Doc: "repeat a string a number of times",
+		Params: []object.BuiltinParam{
+			{Name: "s", Type: object.STRING_OBJ},
+			{Name: "count", Type: object.INTEGER_OBJ},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			str, count := args[0].(*object.String), args[1].(*object.Integer)
+
+			if count.Value < 0 {
+				return newError("second argument to `repeat` must not be negative, got %d", count.Value)
+			}
+
+			// 大きすぎる文字列は作る前に断る
+			// 長さが int に収まらなければ、上限を決めていなくても作れない
+			if count.Value != 0 && int64(len(str.Value)) > int64(maxInt)/count.Value {
+				return exhausted("result of `repeat` is too long: %d bytes * %d", len(str.Value), count.Value)
+			}
+			length := len(str.Value) * int(count.Value)
+			if err := checkSize(env, object.STRING_OBJ, length, sizeOf(&object.String{})+length); err != nil {
+				return err
+			}
+
+			return &object.String{Value: strings.Repeat(str.Value, int(count.Value))}
+		},
+	},
+	// format("%s is %d years old", "Monkey", 3) => "Monkey is 3 years old"
+	"format": {
+		Doc: "format values with %s, %d and %%",
+		Params: []object.BuiltinParam{
+			{Name: "format", Type: object.STRING_OBJ},
+			{Name: "args", Variadic: true},
+		},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return formatString(env, args[0].(*object.String).Value, args[1:])
+		},
+	},
+}
+
+// 文字列だけを受け取る組み込み関数の Params
+func stringParams(names ...string) []object.BuiltinParam {
+	params := make([]object.BuiltinParam, len(names))
+	for i, name := range names {
+		params[i] = object.BuiltinParam{Name: name, Type: object.STRING_OBJ}
+	}
+
+	return params
+}
+
+// stringParams で確かめた引数の値
+func stringValues(args []object.Object) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.(*object.String).Value
+	}
+
+	return strs
+}
+
+// n を 0 から max の範囲に収める
+func clamp(n, max int64) int64 {
+	if n < 0 {
+		return 0
+	}
+
+	if n > max {
+		return max
+	}
+
+	return n
+}
+
+// 作る前に長さがわかる文字列を、作ってよいか確かめる
+// 長さが int に収まらない(mulSize や addSize が maxInt にした)ときは、上限を決めていなくても作れない
+func checkStringSize(env *object.Environment, name string, length int) *object.Error {
+	if length == maxInt {
+		return exhausted("result of `%s` is too long", name)
+	}
+
+	return checkSize(env, object.STRING_OBJ, length, addSize(sizeOf(&object.String{}), length))
+}
+
+// format の書式を展開する
+// %s はどんな値でもとり、%d は整数だけをとる。%% は % そのもの
+// 書き足すたびに長さを確かめ、上限を超える文字列は作り終える前に断る
+func formatString(env *object.Environment, format string, args []object.Object) object.Object {
+	var out bytes.Buffer
+
+	argIdx := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		if i >= len(format) {
+			return newError("format string ends with %%")
+		}
+
+		verb := format[i]
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+
+		if argIdx >= len(args) {
+			return newError("missing argument for %%%c in format string", verb)
+		}
+		arg := args[argIdx]
+		argIdx++
+
+		switch verb {
+		case 's':
+		case 'd':
+			if arg.Type() != object.INTEGER_OBJ {
+				return newError("%%d in format string must be INTEGER, got %s", arg.Type())
+			}
+		default:
+			return newError("unknown verb %%%c in format string", verb)
+		}
+
+		value := arg.Inspect()
+		if err := checkStringSize(env, "format", addSize(out.Len(), len(value))); err != nil {
+			return err
+		}
+		out.WriteString(value)
+	}
+
+	if argIdx != len(args) {
+		return newError("too many arguments for format string. got=%d, want=%d", len(args), argIdx)
+	}
+
+	return &object.String{Value: out.String()}
+}
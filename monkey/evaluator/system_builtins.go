@@ -0,0 +1,66 @@
+package evaluator
+
+import (
+	"go-monkey-shakyo/monkey/object"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// 時刻・乱数・環境変数を扱う組み込み関数
+// どれも結果が実行ごとに変わるので、それぞれの権限を許したときだけ使える
+var systemBuiltins = map[string]*object.Builtin{
+	// now() => 1700000000000
+	// 1970年1月1日(UTC)からのミリ秒
+	"now": {
+		Doc:      "current time in milliseconds since the Unix epoch",
+		Requires: object.CapClock,
+		Params:   []object.BuiltinParam{},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return &object.Integer{Value: time.Now().UnixNano() / int64(time.Millisecond)}
+		},
+	},
+	// random(6) => 0 から 5 のどれか
+	"random": {
+		Doc:      "random integer from 0 up to but not including n",
+		Requires: object.CapRandom,
+		Params:   []object.BuiltinParam{{Name: "n", Type: object.INTEGER_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			n := args[0].(*object.Integer).Value
+			if n <= 0 {
+				return newError("argument to `random` must be positive, got %d", n)
+			}
+
+			return &object.Integer{Value: randomInt63n(n)}
+		},
+	},
+	// getenv("HOME") => "/home/monkey"
+	// 設定されていなければ null
+	"getenv": {
+		Doc:      "value of an environment variable, or null if it is not set",
+		Requires: object.CapEnv,
+		Params:   []object.BuiltinParam{{Name: "name", Type: object.STRING_OBJ}},
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			value, ok := os.LookupEnv(args[0].(*object.String).Value)
+			if !ok {
+				return NULL
+			}
+
+			return &object.String{Value: value}
+		},
+	},
+}
+
+// rand.Rand は複数のゴルーチンから使えないので、ロックして使う
+var (
+	randomMu     sync.Mutex
+	randomSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func randomInt63n(n int64) int64 {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+
+	return randomSource.Int63n(n)
+}
@@ -0,0 +1,459 @@
+package interp
+
+import (
+	"fmt"
+	"go-monkey-shakyo/monkey/evaluator"
+	"go-monkey-shakyo/monkey/object"
+	"reflect"
+	"sort"
+)
+
+// Go の値と Monkey の値の対応
+//
+//	Go                           Monkey
+//	nil                      <-> NULL
+//	bool                     <-> BOOLEAN
+//	int, int8, ..., uint64   <-> INTEGER(Go にするときは int64)
+//	string                   <-> STRING
+//	スライス, 配列            <-> ARRAY(Go にするときは []interface{})
+//	map                      <-> HASH(Go にするときは、キーがすべて文字列なら map[string]interface{}、
+//	                                   そうでなければ map[interface{}]interface{})
+//	func                      -> BUILTIN(引数と戻り値も自動で変換する)
+//
+// object.Object はそのまま渡す。関数のように Go の値にできないものは、object.Object のまま返す
+
+var (
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	objectType     = reflect.TypeOf((*object.Object)(nil)).Elem()
+	emptyInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// Go の値を Monkey の値にする
+// 自分自身を含む map やスライスは Monkey の値にできないので、エラーを返す
+func ToObject(v interface{}) (object.Object, error) {
+	return toObject(reflect.ValueOf(v), make(map[visit]bool))
+}
+
+// 変換している途中の map・スライス・ポインタ
+// 変換し終える前に同じものにもう一度たどり着いたら、値が自分自身を含んでいる
+type visit struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+func toObject(v reflect.Value, visiting map[visit]bool) (object.Object, error) {
+	if !v.IsValid() {
+		return evaluator.NULL, nil
+	}
+
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Ptr:
+		if v.IsNil() || v.Pointer() == 0 {
+			break
+		}
+
+		key := visit{typ: v.Type(), ptr: v.Pointer()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+
+		if visiting[key] {
+			return nil, fmt.Errorf("cyclic value of Go type %s", v.Type())
+		}
+		visiting[key] = true
+		defer delete(visiting, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > 1<<63-1 {
+			return nil, fmt.Errorf("%d overflows INTEGER", n)
+		}
+		return &object.Integer{Value: int64(n)}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			el, err := toObject(v.Index(i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, el)
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		return mapToHash(v, visiting)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return toObject(v.Elem(), visiting)
+	case reflect.Func:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return wrapFunc(v)
+	default:
+		return nil, fmt.Errorf("unsupported Go type %s", v.Type())
+	}
+}
+
+// Go の map は順番が決まらないので、キーを並べ替えてから追加する
+func mapToHash(v reflect.Value, visiting map[visit]bool) (object.Object, error) {
+	type entry struct {
+		key   object.Hashable
+		value reflect.Value
+	}
+
+	var entries []entry
+	iter := v.MapRange()
+	for iter.Next() {
+		keyObj, err := toObject(iter.Key(), visiting)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := object.AsHashable(keyObj)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", keyObj.Type())
+		}
+
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return lessKey(entries[i].key, entries[j].key)
+	})
+
+	hash := object.NewHash()
+	for _, e := range entries {
+		value, err := toObject(e.value, visiting)
+		if err != nil {
+			return nil, err
+		}
+		hash.Set(e.key, value)
+	}
+
+	return hash, nil
+}
+
+// 整数は数の順、文字列は辞書順、型が違えば型の名前の順
+func lessKey(a, b object.Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+
+	switch a := a.(type) {
+	case *object.Integer:
+		return a.Value < b.(*object.Integer).Value
+	case *object.String:
+		return a.Value < b.(*object.String).Value
+	default:
+		return a.Inspect() < b.Inspect()
+	}
+}
+
+// Go の関数を組み込み関数にする
+// 戻り値は、なし・値・error・(値, error) のどれか。error が nil でなければ Monkey のエラーになる
+func wrapFunc(fn reflect.Value) (object.Object, error) {
+	t := fn.Type()
+
+	numOut := t.NumOut()
+	hasErr := numOut > 0 && t.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !hasErr) {
+		return nil, fmt.Errorf("unsupported function type %s: must return at most a value and an error", t)
+	}
+
+	numIn := t.NumIn()
+
+	return &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) (result object.Object) {
+			// Go の関数が panic しても、埋め込んだプロセスごと落とさずに Monkey のエラーにする
+			defer func() {
+				if r := recover(); r != nil {
+					err, _ := r.(error)
+					result = &object.Error{Message: fmt.Sprintf("panic in Go function: %v", r), Err: err}
+				}
+			}()
+
+			if t.IsVariadic() {
+				if len(args) < numIn-1 {
+					return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d or more", len(args), numIn-1)}
+				}
+			} else if len(args) != numIn {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), numIn)}
+			}
+
+			in := make([]reflect.Value, len(args))
+			for i, arg := range args {
+				var paramType reflect.Type
+				if t.IsVariadic() && i >= numIn-1 {
+					paramType = t.In(numIn - 1).Elem()
+				} else {
+					paramType = t.In(i)
+				}
+
+				val, err := fromObjectTo(arg, paramType)
+				if err != nil {
+					return &object.Error{Message: fmt.Sprintf("argument %d: %s", i, err)}
+				}
+				in[i] = val
+			}
+
+			out := fn.Call(in)
+
+			if hasErr {
+				if err, _ := out[numOut-1].Interface().(error); err != nil {
+					return &object.Error{Message: err.Error(), Err: err}
+				}
+				out = out[:numOut-1]
+			}
+
+			if len(out) == 0 {
+				return evaluator.NULL
+			}
+
+			result, err := toObject(out[0], make(map[visit]bool))
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("return value: %s", err)}
+			}
+
+			return result
+		},
+	}, nil
+}
+
+// Go の関数の引数を、組み込み関数の引数の宣言にする
+// Go の型から Monkey の型が1つに決まらない引数(interface{} など)は型を確かめない
+func funcParams(t reflect.Type) []object.BuiltinParam {
+	params := make([]object.BuiltinParam, 0, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		paramType := t.In(i)
+		variadic := t.IsVariadic() && i == t.NumIn()-1
+		if variadic {
+			paramType = paramType.Elem()
+		}
+
+		params = append(params, object.BuiltinParam{
+			Name:     fmt.Sprintf("arg%d", i),
+			Type:     monkeyType(paramType),
+			Variadic: variadic,
+		})
+	}
+
+	return params
+}
+
+func monkeyType(t reflect.Type) object.ObjectType {
+	if t.Implements(objectType) {
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return object.BOOLEAN_OBJ
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return object.INTEGER_OBJ
+	case reflect.String:
+		return object.STRING_OBJ
+	case reflect.Slice, reflect.Array:
+		return object.ARRAY_OBJ
+	case reflect.Map:
+		return object.HASH_OBJ
+	default:
+		return ""
+	}
+}
+
+// Monkey の値を Go の値にする
+func FromObject(obj object.Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case nil, *object.NULL:
+		return nil, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Array:
+		elements := make([]interface{}, 0, len(obj.Elements))
+		for _, el := range obj.Elements {
+			v, err := FromObject(el)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, v)
+		}
+		return elements, nil
+	case *object.Hash:
+		return hashToMap(obj)
+	default:
+		return obj, nil
+	}
+}
+
+func hashToMap(hash *object.Hash) (interface{}, error) {
+	stringKeys := true
+	for _, pair := range hash.Pairs() {
+		if _, ok := pair.Key.(*object.String); !ok {
+			stringKeys = false
+			break
+		}
+	}
+
+	if stringKeys {
+		m := make(map[string]interface{}, hash.Len())
+		for _, pair := range hash.Pairs() {
+			v, err := FromObject(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			m[pair.Key.(*object.String).Value] = v
+		}
+		return m, nil
+	}
+
+	m := make(map[interface{}]interface{}, hash.Len())
+	for _, pair := range hash.Pairs() {
+		switch pair.Key.(type) {
+		case *object.Integer, *object.Boolean, *object.String:
+		default:
+			return nil, fmt.Errorf("hash key %s cannot be converted to a Go map key", pair.Key.Type())
+		}
+
+		k, _ := FromObject(pair.Key)
+		v, err := FromObject(pair.Value)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// Monkey の値を、Go の型 t の値にする(Go の関数に渡す引数用)
+func fromObjectTo(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t == emptyInterface {
+		v, err := FromObject(obj)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(v), nil
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	mismatch := fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return reflect.Value{}, mismatch
+		}
+		return reflect.ValueOf(b.Value).Convert(t), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := obj.(*object.Integer)
+		if !ok {
+			return reflect.Value{}, mismatch
+		}
+		v := reflect.New(t).Elem()
+		if v.OverflowInt(n.Value) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", n.Value, t)
+		}
+		v.SetInt(n.Value)
+		return v, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := obj.(*object.Integer)
+		if !ok {
+			return reflect.Value{}, mismatch
+		}
+		v := reflect.New(t).Elem()
+		if n.Value < 0 || v.OverflowUint(uint64(n.Value)) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", n.Value, t)
+		}
+		v.SetUint(uint64(n.Value))
+		return v, nil
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return reflect.Value{}, mismatch
+		}
+		return reflect.ValueOf(s.Value).Convert(t), nil
+	case reflect.Slice:
+		arr, ok := obj.(*object.Array)
+		if !ok {
+			return reflect.Value{}, mismatch
+		}
+		v := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+		for i, el := range arr.Elements {
+			ev, err := fromObjectTo(el, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.Index(i).Set(ev)
+		}
+		return v, nil
+	case reflect.Array:
+		arr, ok := obj.(*object.Array)
+		if !ok {
+			return reflect.Value{}, mismatch
+		}
+		if len(arr.Elements) != t.Len() {
+			return reflect.Value{}, fmt.Errorf("cannot use ARRAY of length %d as %s", len(arr.Elements), t)
+		}
+		v := reflect.New(t).Elem()
+		for i, el := range arr.Elements {
+			ev, err := fromObjectTo(el, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.Index(i).Set(ev)
+		}
+		return v, nil
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return reflect.Value{}, mismatch
+		}
+		v := reflect.MakeMapWithSize(t, hash.Len())
+		for _, pair := range hash.Pairs() {
+			kv, err := fromObjectTo(pair.Key, t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			vv, err := fromObjectTo(pair.Value, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.SetMapIndex(kv, vv)
+		}
+		return v, nil
+	default:
+		return reflect.Value{}, mismatch
+	}
+}
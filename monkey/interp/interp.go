@@ -0,0 +1,323 @@
+package interp
+
+import (
+	"context"
+	"fmt"
+	"go-monkey-shakyo/monkey/ast"
+	"go-monkey-shakyo/monkey/evaluator"
+	"go-monkey-shakyo/monkey/lexer"
+	"go-monkey-shakyo/monkey/object"
+	"go-monkey-shakyo/monkey/parser"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Go のプログラムに Monkey を組み込むための入り口
+// 字句解析器・構文解析器・評価器と環境をまとめて持ち、値は Go の値に変換してやりとりする
+//
+//	in := interp.New(interp.WithLimits(object.Limits{MaxSteps: 100000}))
+//	in.Set("name", "Monkey")
+//	v, err := in.Eval(ctx, `"Hello " + name`) // v == "Hello Monkey"
+//
+// 束縛は Eval をまたいで残るので、REPL と同じように少しずつ評価できる
+//
+// Interpreter どうしは何も共有しないので、リクエストごとに New して並行に評価してよい
+// 1つの Interpreter を複数のゴルーチンから同時に使ってもよい。束縛は共有するが、
+// 資源の上限とコンテキストは Eval や Call ごとに別々に数え、ほかの評価が終わっても止まらない
+type Interpreter struct {
+	env        *object.Environment
+	parserOpts []parser.Option
+	timeout    time.Duration
+}
+
+type Option func(*Interpreter)
+
+// 評価に使える資源の上限
+func WithLimits(limits object.Limits) Option {
+	return func(in *Interpreter) {
+		in.env.SetLimits(limits)
+	}
+}
+
+// readFile などのファイル操作の組み込み関数が扱えるディレクトリ
+func WithFileRoot(dir string) Option {
+	return func(in *Interpreter) {
+		in.env.Settings().FileRoot = dir
+	}
+}
+
+// スクリプトに許す権限を caps だけにする
+// 指定しなければどの権限も許さないので、外の世界に触れる組み込み関数や import は使えない
+// 必要なものだけを渡し、すべて許すなら object.AllCapabilities を渡す
+//
+//	in := interp.New(interp.WithCapabilities(object.CapStdout, object.CapClock)) // puts と now だけ使える
+//	in := interp.New(interp.WithCapabilities(object.AllCapabilities...))
+func WithCapabilities(caps ...object.Capability) Option {
+	return func(in *Interpreter) {
+		in.env.Settings().Capabilities = object.NewCapabilities(caps...)
+	}
+}
+
+// puts と print が書き出す先。指定しなければプロセスの標準出力
+func WithStdout(w io.Writer) Option {
+	return func(in *Interpreter) {
+		in.env.Settings().Stdout = w
+	}
+}
+
+// eprint が書き出す先。指定しなければプロセスの標準エラー出力
+func WithStderr(w io.Writer) Option {
+	return func(in *Interpreter) {
+		in.env.Settings().Stderr = w
+	}
+}
+
+// 範囲外の添字や存在しないキーでの添字アクセスをエラーにする
+func WithStrictIndex() Option {
+	return func(in *Interpreter) {
+		in.env.Settings().StrictIndex = true
+	}
+}
+
+// import の起点となるディレクトリと、そこで見つからないときに探すディレクトリ
+// import できるのはこれらのディレクトリの中のモジュールだけで、object.CapModules の権限も必要
+func WithModulePath(dir string, searchPath ...string) Option {
+	return func(in *Interpreter) {
+		in.env.SetDir(dir)
+		in.env.Modules().SearchPath = searchPath
+	}
+}
+
+// Eval や Call 1回あたりにかけてよい時間
+// 過ぎると次の文か関数呼び出しで評価を止め、*InterruptedError を返す
+func WithTimeout(d time.Duration) Option {
+	return func(in *Interpreter) {
+		in.timeout = d
+	}
+}
+
+// ソースを構文解析するときの構文解析器のオプション
+func WithParserOptions(opts ...parser.Option) Option {
+	return func(in *Interpreter) {
+		in.parserOpts = append(in.parserOpts, opts...)
+	}
+}
+
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{env: object.NewEnvironment()}
+	in.env.Settings().Capabilities = object.NewCapabilities()
+
+	for _, opt := range opts {
+		opt(in)
+	}
+
+	return in
+}
+
+// 評価に使っている環境
+// ここにない細かい設定をしたいときに使う
+func (in *Interpreter) Env() *object.Environment {
+	return in.env
+}
+
+// src を評価して、最後の式の値を Go の値にして返す
+// 構文エラーは *ParseError、評価中のエラーは *RuntimeError になる
+// ctx が評価を始める前に終わっていれば、評価せずに ctx.Err() を返す
+// 評価の途中で ctx が取り消されたり期限が過ぎたりすると、次の文か関数呼び出しで止めて *InterruptedError を返す
+// 資源の上限は Eval を呼ぶたびに数え直す
+// Eval から戻ると、評価中に spawn した関数や作ったジェネレータも止まる
+func (in *Interpreter) Eval(ctx context.Context, src string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(src), in.parserOpts...)
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		return nil, &ParseError{Source: src, Diagnostics: p.Diagnostics()}
+	}
+
+	env, cancel := in.begin(ctx)
+	defer cancel()
+
+	return in.result(evaluator.Eval(program, env))
+}
+
+// name の関数を、Go の値を引数にして呼び出す
+// スクリプトの中と同じように名前を解決するので、組み込み関数も呼び出せる
+func (in *Interpreter) Call(name string, args ...interface{}) (interface{}, error) {
+	return in.CallContext(context.Background(), name, args...)
+}
+
+// ctx で止められるようにして Call する
+func (in *Interpreter) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	env, cancel := in.begin(ctx)
+	defer cancel()
+
+	fn := evaluator.Eval(&ast.Identifier{Value: name}, env)
+	if _, ok := fn.(*object.Error); ok {
+		return nil, fmt.Errorf("interp: %s is not defined", name)
+	}
+
+	objs := make([]object.Object, 0, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("interp: argument %d to %s: %w", i, name, err)
+		}
+		objs = append(objs, obj)
+	}
+
+	return in.result(evaluator.Apply(fn, objs, env))
+}
+
+// この Interpreter だけで使える組み込み関数を追加する
+// 同じ名前の標準の組み込み関数があれば上書きする。Params を宣言すれば、呼び出す前に引数を確かめる
+func (in *Interpreter) RegisterBuiltin(b *object.Builtin) error {
+	if b.Name == "" {
+		return fmt.Errorf("interp: builtin must have a name")
+	}
+	if b.Fn == nil {
+		return fmt.Errorf("interp: builtin %s has no function", b.Name)
+	}
+
+	in.env.Builtins().Register(b)
+	return nil
+}
+
+// Go の関数を name の組み込み関数として追加する
+// 引数の数と型は Go の関数の型から決まり、合わない呼び出しは関数を呼ぶ前にエラーになる
+//
+//	in.RegisterFunc("double", func(n int) int { return n * 2 }, "double a number")
+func (in *Interpreter) RegisterFunc(name string, fn interface{}, doc string) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("interp: %s: %T is not a function", name, fn)
+	}
+
+	obj, err := wrapFunc(v)
+	if err != nil {
+		return fmt.Errorf("interp: %s: %w", name, err)
+	}
+
+	b := obj.(*object.Builtin)
+	b.Name = name
+	b.Doc = doc
+	b.Params = funcParams(v.Type())
+
+	return in.RegisterBuiltin(b)
+}
+
+// 組み込み関数を使えなくする。スクリプトからは定義されていない名前に見える
+func (in *Interpreter) HideBuiltin(names ...string) {
+	for _, name := range names {
+		in.env.Builtins().Hide(name)
+	}
+}
+
+// この Interpreter で使える組み込み関数を名前順に並べたもの
+func (in *Interpreter) Builtins() []*object.Builtin {
+	return evaluator.Builtins(in.env)
+}
+
+// Go の値を Monkey の値にして、name に束縛する
+func (in *Interpreter) Set(name string, v interface{}) error {
+	obj, err := ToObject(v)
+	if err != nil {
+		return fmt.Errorf("interp: %s: %w", name, err)
+	}
+
+	in.env.Set(name, obj)
+	return nil
+}
+
+// name に束縛された値を Go の値にして返す
+func (in *Interpreter) Get(name string) (interface{}, error) {
+	obj, ok := in.env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("interp: %s is not defined", name)
+	}
+
+	return FromObject(obj)
+}
+
+// 1回の評価に使う環境と、評価が終わったら呼ぶ関数を返す
+// 環境は束縛を Interpreter と共有し、資源の使った量とコンテキストはこの評価だけのものを持つ
+// 評価が終わったらコンテキストを取り消して、評価中に spawn した関数や作ったジェネレータを止める
+// (評価中に登録した Go の関数などから呼ばれた Eval や Call も、別の評価として数える)
+func (in *Interpreter) begin(ctx context.Context) (*object.Environment, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if in.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, in.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	return in.env.NewEvaluation(ctx), cancel
+}
+
+func (in *Interpreter) result(obj object.Object) (interface{}, error) {
+	if obj == nil {
+		return nil, nil
+	}
+
+	if evaluator.IsInterrupted(obj) {
+		return nil, &InterruptedError{Err: obj.(*object.Error).Err}
+	}
+
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, &RuntimeError{Message: errObj.Message, Err: errObj.Err}
+	}
+
+	return FromObject(obj)
+}
+
+// ソースの構文エラー
+type ParseError struct {
+	Source      string
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	var msgs []string
+	for _, d := range e.Diagnostics {
+		msgs = append(msgs, d.String())
+	}
+
+	return "parse error: " + strings.Join(msgs, "; ")
+}
+
+// 評価中に起きたエラー(Monkey の *object.Error)
+// 資源の上限を超えたときは errors.Is(err, object.ErrResourceExhausted) になる
+type RuntimeError struct {
+	Message string
+	Err     error
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Message
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// 評価が ctx の取り消しや期限切れで途中で止まったときのエラー
+// errors.Is(err, context.DeadlineExceeded) のように原因を調べられる
+type InterruptedError struct {
+	Err error
+}
+
+func (e *InterruptedError) Error() string {
+	return "evaluation interrupted: " + e.Err.Error()
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,491 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-monkey-shakyo/monkey/object"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{"整数", "1 + 2", int64(3)},
+		{"文字列", `"a" + "b"`, "ab"},
+		{"真偽値", "1 < 2", true},
+		{"NULL", "if (false) { 1 }", nil},
+		{"let 文の値はない", "let x = 1;", nil},
+		{"配列", `[1, "a", [true]]`, []interface{}{int64(1), "a", []interface{}{true}}},
+		{"文字列キーのハッシュ", `{"a": 1, "b": [2]}`, map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2)}}},
+		{"文字列以外のキーのハッシュ", `{1: "a", true: "b"}`, map[interface{}]interface{}{int64(1): "a", true: "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New().Eval(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("want=%#v, got=%#v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	in := New()
+
+	_, err := in.Eval(context.Background(), "let = 1;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError, got %T (%v)", err, err)
+	}
+	if len(parseErr.Diagnostics) == 0 || !strings.HasPrefix(err.Error(), "parse error: 1:5: error[E0001]") {
+		t.Errorf("wrong parse error: %s", err)
+	}
+
+	_, err = in.Eval(context.Background(), "1 + true")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if runtimeErr.Message != "type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong runtime error: %s", runtimeErr.Message)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := in.Eval(ctx, "1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+// 束縛は Eval をまたいで残る
+func TestEvalKeepsBindings(t *testing.T) {
+	in := New()
+	ctx := context.Background()
+
+	if _, err := in.Eval(ctx, "let add = fn(a, b) { a + b };"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := in.Eval(ctx, "add(1, 2)")
+	if err != nil || got != int64(3) {
+		t.Fatalf("want=3, got=%v (%v)", got, err)
+	}
+}
+
+// ジェネレータは作った Eval が終わると止まるので、次の Eval では続きを取り出せない
+func TestGeneratorEndsWithEval(t *testing.T) {
+	in := New()
+	ctx := context.Background()
+
+	got, err := in.Eval(ctx, "let nat = fn*() { let loop = fn(i) { yield i; loop(i + 1) }; loop(0) }; let g = nat(); [next(g), next(g)]")
+	if err != nil || !reflect.DeepEqual(got, []interface{}{int64(0), int64(1)}) {
+		t.Fatalf("want=[0, 1], got=%v (%v)", got, err)
+	}
+
+	_, err = in.Eval(ctx, "next(g)")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "generator was stopped: the evaluation that created it has ended" {
+		t.Errorf("wrong error: %T (%v)", err, err)
+	}
+
+	// 新しく作れば、その Eval の中では使える
+	if got, err := in.Eval(ctx, "next(nat())"); err != nil || got != int64(0) {
+		t.Errorf("want=0, got=%v (%v)", got, err)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	type myString string
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"nil", nil, nil},
+		{"int", 42, int64(42)},
+		{"uint8", uint8(7), int64(7)},
+		{"string", "hi", "hi"},
+		{"名前つきの型", myString("named"), "named"},
+		{"bool", true, true},
+		{"スライス", []int{1, 2}, []interface{}{int64(1), int64(2)}},
+		{"配列", [2]string{"a", "b"}, []interface{}{"a", "b"}},
+		{"map", map[string]int{"b": 2, "a": 1}, map[string]interface{}{"a": int64(1), "b": int64(2)}},
+		{"interface{} の入れ子", []interface{}{1, "a", nil}, []interface{}{int64(1), "a", nil}},
+		{"ポインタ", func() *int { n := 3; return &n }(), int64(3)},
+		{"object.Object はそのまま", &object.Integer{Value: 5}, int64(5)},
+		{"同じ値を何度も含む", func() interface{} { m := map[string]interface{}{"a": 1}; return []interface{}{m, m} }(), []interface{}{map[string]interface{}{"a": int64(1)}, map[string]interface{}{"a": int64(1)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := New()
+			if err := in.Set("x", tt.value); err != nil {
+				t.Fatalf("Set: %s", err)
+			}
+
+			got, err := in.Get("x")
+			if err != nil {
+				t.Fatalf("Get: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("want=%#v, got=%#v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		err   string
+	}{
+		{"構造体", struct{}{}, "interp: x: unsupported Go type struct {}"},
+		{"大きすぎる uint64", uint64(1 << 63), "interp: x: 9223372036854775808 overflows INTEGER"},
+		{"戻り値の多すぎる関数", func() (int, int) { return 0, 0 }, "interp: x: unsupported function type func() (int, int): must return at most a value and an error"},
+		{"自分自身を含む map", func() interface{} { m := map[string]interface{}{}; m["self"] = m; return m }(), "interp: x: cyclic value of Go type map[string]interface {}"},
+		{"自分自身を含むスライス", func() interface{} { s := []interface{}{nil}; s[0] = s; return s }(), "interp: x: cyclic value of Go type []interface {}"},
+		{"自分自身を指すポインタ", func() interface{} { var p interface{}; p = &p; return p }(), "interp: x: cyclic value of Go type *interface {}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().Set("x", tt.value)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("want=%q, got=%v", tt.err, err)
+			}
+		})
+	}
+
+	if _, err := New().Get("nothing"); err == nil || err.Error() != "interp: nothing is not defined" {
+		t.Errorf("wrong error for an undefined name: %v", err)
+	}
+}
+
+// Go の関数を Monkey から呼び出す
+func TestGoFunctions(t *testing.T) {
+	in := New()
+
+	funcs := map[string]interface{}{
+		"add":   func(a, b int) int { return a + b },
+		"greet": func(name string) string { return "Hello " + name },
+		"sum": func(nums ...int64) int64 {
+			var total int64
+			for _, n := range nums {
+				total += n
+			}
+			return total
+		},
+		"fail": func(msg string) (int, error) { return 0, errors.New(msg) },
+		"keys": func(m map[string]int) []string {
+			var keys []string
+			for k := range m {
+				keys = append(keys, k)
+			}
+			return keys
+		},
+		"describe": func(v interface{}) string { return reflect.TypeOf(v).String() },
+		"small":    func(n int8) int8 { return n },
+		"sumpair":  func(a [2]int) int { return a[0] + a[1] },
+		"nothing":  func() {},
+		"explode":  func() int { panic("kaboom") },
+	}
+	for name, fn := range funcs {
+		if err := in.Set(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+		err      string
+	}{
+		{input: "add(1, 2)", expected: int64(3)},
+		{input: `greet("Monkey")`, expected: "Hello Monkey"},
+		{input: "sum()", expected: int64(0)},
+		{input: "sum(1, 2, 3)", expected: int64(6)},
+		{input: `keys({"a": 1})`, expected: []interface{}{"a"}},
+		{input: `describe([1])`, expected: "[]interface {}"},
+		{input: "nothing()", expected: nil},
+		{input: "map([1, 2], fn(x) { add(x, x) })", expected: []interface{}{int64(2), int64(4)}},
+		{input: `fail("boom")`, err: "boom"},
+		{input: "explode()", err: "panic in Go function: kaboom"},
+		{input: "add(1)", err: "wrong number of arguments. got=1, want=2"},
+		{input: `add(1, "a")`, err: "argument 1: cannot use STRING as int"},
+		{input: "small(1000)", err: "argument 0: 1000 overflows int8"},
+		{input: `keys({"a": "b"})`, err: "argument 0: cannot use STRING as int"},
+		{input: "sumpair([1, 2])", expected: int64(3)},
+		{input: "sumpair([1, 2, 3])", err: "argument 0: cannot use ARRAY of length 3 as [2]int"},
+		{input: `sumpair([1, "a"])`, err: "argument 0: cannot use STRING as int"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := in.Eval(context.Background(), tt.input)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("want error %q, got=%v (%v)", tt.err, err, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("want=%#v, got=%#v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// Go の関数が返した error や panic した error は、RuntimeError から errors.Is / errors.As で取り出せる
+func TestGoFunctionErrors(t *testing.T) {
+	errNotFound := errors.New("not found")
+
+	in := New()
+	funcs := map[string]interface{}{
+		"lookup": func() (int, error) { return 0, fmt.Errorf("lookup: %w", errNotFound) },
+		"crash":  func() int { panic(errNotFound) },
+	}
+	for name, fn := range funcs {
+		if err := in.Set(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, src := range []string{"lookup()", "crash()"} {
+		_, err := in.Eval(context.Background(), src)
+
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("%s: want *RuntimeError, got=%T (%v)", src, err, err)
+		}
+		if !errors.Is(err, errNotFound) {
+			t.Errorf("%s: error of the Go function is lost: %v", src, err)
+		}
+	}
+}
+
+// Monkey の関数を Go から呼び出す
+func TestCall(t *testing.T) {
+	in := New()
+	if _, err := in.Eval(context.Background(), `let join = fn(xs, sep) { reduce(xs, "", fn(acc, x) { if (acc == "") { x } else { acc + sep + x } }) };`); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := in.Call("join", []string{"a", "b", "c"}, "-")
+	if err != nil || got != "a-b-c" {
+		t.Fatalf("want=a-b-c, got=%v (%v)", got, err)
+	}
+
+	if _, err := in.Call("join", []string{"a"}); err == nil || err.Error() != "wrong number of arguments. got=1, want=2" {
+		t.Errorf("wrong arity error: %v", err)
+	}
+
+	if _, err := in.Call("nothing"); err == nil || err.Error() != "interp: nothing is not defined" {
+		t.Errorf("wrong undefined error: %v", err)
+	}
+
+	// 組み込み関数も呼び出せる
+	got, err = in.Call("len", "abc")
+	if err != nil || got != int64(3) {
+		t.Errorf("want=3, got=%v (%v)", got, err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+
+	in := New(WithStrictIndex())
+	if _, err := in.Eval(ctx, "[1][5]"); err == nil || err.Error() != "index out of range: 5 (ARRAY length 1)" {
+		t.Errorf("WithStrictIndex: %v", err)
+	}
+
+	in = New(WithLimits(object.Limits{MaxSteps: 100}))
+	if _, err := in.Eval(ctx, "let f = fn() { f() }; f()"); err == nil || err.Error() != "step limit exceeded" {
+		t.Errorf("WithLimits: %v", err)
+	}
+
+	in = New(WithLimits(object.Limits{MaxDepth: 10, MaxLength: 100, MaxAlloc: 100000}))
+	for _, src := range []string{
+		"let f = fn() { f() }; f()",
+		"range(1000)",
+		`let g = fn(s) { g(s + s) }; g("a")`,
+	} {
+		if _, err := in.Eval(ctx, src); !errors.Is(err, object.ErrResourceExhausted) {
+			t.Errorf("WithLimits: %s: want a resource exhausted error, got %v", src, err)
+		}
+	}
+
+	// 指定しなければどの権限も許さない
+	in = New()
+	if _, err := in.Eval(ctx, "now()"); err == nil || err.Error() != "`now` requires the clock capability, which is not granted" {
+		t.Errorf("New: no capability should be granted by default: %v", err)
+	}
+
+	dir := t.TempDir()
+	in = New(WithCapabilities(object.CapClock), WithFileRoot(dir))
+	if _, err := in.Eval(ctx, "now()"); err != nil {
+		t.Errorf("WithCapabilities: clock should be granted: %v", err)
+	}
+	if _, err := in.Eval(ctx, `readFile("a.txt")`); err == nil || err.Error() != "`readFile` requires the filesystem capability, which is not granted" {
+		t.Errorf("WithCapabilities: filesystem should not be granted: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "m.monkey"), []byte("export let x = 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in = New(WithCapabilities(), WithModulePath(dir))
+	if _, err := in.Eval(ctx, `import "m"`); err == nil || err.Error() != "`import` requires the modules capability, which is not granted" {
+		t.Errorf("WithCapabilities: modules should not be granted: %v", err)
+	}
+	in = New(WithCapabilities(object.CapModules), WithModulePath(dir))
+	if v, err := in.Eval(ctx, `import "m"["x"]`); err != nil || v != int64(1) {
+		t.Errorf("WithCapabilities: modules should be granted: %v, %v", v, err)
+	}
+
+	var stdout, stderr strings.Builder
+	in = New(WithStdout(&stdout), WithStderr(&stderr), WithCapabilities(object.CapStdout))
+	if _, err := in.Eval(ctx, `puts("a"); print("b"); eprint("c")`); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "a\nb" || stderr.String() != "c\n" {
+		t.Errorf("WithStdout/WithStderr: stdout=%q, stderr=%q", stdout.String(), stderr.String())
+	}
+
+	// 上限は Eval ごとに数える
+	in = New(WithLimits(object.Limits{MaxSteps: 100}))
+	for i := 0; i < 10; i++ {
+		if _, err := in.Eval(ctx, "1 + 2 + 3 + 4 + 5"); err != nil {
+			t.Fatalf("WithLimits: the step budget should be per Eval: %v", err)
+		}
+	}
+
+	in = New(WithFileRoot(dir), WithCapabilities(object.CapFilesystem))
+	got, err := in.Eval(ctx, `writeFile("a.txt", "x"); readFile("a.txt")`)
+	if err != nil || got != "x" {
+		t.Errorf("WithFileRoot: %v (%v)", got, err)
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	in := New()
+	if err := in.RegisterFunc("double", func(n int) int { return n * 2 }, "double a number"); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.RegisterFunc("len", func(s string) string { return "overridden" }, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.RegisterFunc("sumpair", func(a [2]int) int { return a[0] + a[1] }, ""); err != nil {
+		t.Fatal(err)
+	}
+	in.HideBuiltin("readFile", "puts")
+
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+		err      string
+	}{
+		{"Go の関数", `double(21)`, int64(42), ""},
+		{"配列を受け取る Go の関数", `sumpair([1, 2])`, int64(3), ""},
+		{"上書きした組み込み関数", `len("abc")`, "overridden", ""},
+		{"隠した組み込み関数", `puts("x")`, nil, "identifier not found: puts"},
+		{"引数の数", `double(1, 2)`, nil, "wrong number of arguments to `double`. got=2, want=1"},
+		{"引数の型", `double("a")`, nil, "argument `arg0` to `double` must be INTEGER, got STRING"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := in.Eval(context.Background(), tt.input)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("want error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil || got != tt.expected {
+				t.Fatalf("want=%v, got=%v (%v)", tt.expected, got, err)
+			}
+		})
+	}
+
+	var double *object.Builtin
+	for _, b := range in.Builtins() {
+		if b.Name == "double" {
+			double = b
+		}
+		if b.Name == "readFile" {
+			t.Errorf("hidden builtin is listed")
+		}
+	}
+	if double == nil || double.Signature() != "double(arg0: INTEGER)" || double.Doc != "double a number" {
+		t.Errorf("wrong metadata: %+v", double)
+	}
+
+	// 別の Interpreter には影響しない
+	if got, err := New().Eval(context.Background(), `len("abc")`); err != nil || got != int64(3) {
+		t.Errorf("registration leaked to another interpreter: %v (%v)", got, err)
+	}
+
+	if err := in.RegisterFunc("bad", 1, ""); err == nil || err.Error() != "interp: bad: int is not a function" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	const loop = "let f = fn(n) { f(n + 1) }; f(0)"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	in := New()
+	_, err := in.Eval(ctx, loop)
+
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want *InterruptedError caused by the deadline, got %T(%v)", err, err)
+	}
+
+	// 止めたあとも同じ Interpreter で評価を続けられる
+	if got, err := in.Eval(context.Background(), "1 + 1"); err != nil || got != int64(2) {
+		t.Errorf("want=2, got=%v (%v)", got, err)
+	}
+
+	// 評価の途中で取り消す
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := in.Eval(ctx, loop); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+
+	in = New(WithTimeout(50 * time.Millisecond))
+	if _, err := in.Eval(context.Background(), loop); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WithTimeout: want context.DeadlineExceeded, got %v", err)
+	}
+
+	if _, err := in.Eval(context.Background(), "let g = fn() { f(0) };"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Call("g"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call with WithTimeout: want context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-monkey-shakyo/monkey/object"
+	"go-monkey-shakyo/monkey/parser"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 1つのプロセスで多くの Interpreter を同時に動かしても、互いに影響しないことを確かめる
+// データ競合は go test -race ./monkey/... で検出する
+
+// それぞれのゴルーチンで評価するスクリプトと、期待する結果
+var parallelScripts = []struct {
+	name     string
+	src      string
+	expected interface{}
+}{
+	{
+		"クロージャと再帰",
+		`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)`,
+		int64(610),
+	},
+	{
+		"ハッシュとJSON",
+		`let h = merge({"name": "monkey"}, {"n": id}); jsonStringify(h)`,
+		nil,
+	},
+	{
+		"配列の組み込み関数",
+		`sort(map(filter(range(10), fn(x) { x > 4 }), fn(x) { x * 2 }), fn(a, b) { a > b })`,
+		[]interface{}{int64(18), int64(16), int64(14), int64(12), int64(10)},
+	},
+	{
+		"出力",
+		`puts(id); print("done")`,
+		nil,
+	},
+	{
+		"spawn とチャネル",
+		`let ch = channel(); map(range(1, 6), fn(n) { spawn(fn() { send(ch, n * id) }) }); reduce(range(5), 0, fn(acc, i) { acc + recv(ch) })`,
+		nil,
+	},
+	{
+		"スライスと文字列",
+		`let s = "interpreter"; [s[0:5], s[-5:], reverse(s)[::2]]`,
+		[]interface{}{"inter", "reter", "rtrrti"},
+	},
+}
+
+func TestParallelInterpreters(t *testing.T) {
+	const workers = 16
+
+	for w := 0; w < workers; w++ {
+		id := w
+		t.Run(fmt.Sprintf("worker%d", id), func(t *testing.T) {
+			t.Parallel()
+
+			var stdout bytes.Buffer
+			in := New(
+				WithStdout(&stdout),
+				WithCapabilities(object.CapStdout),
+				WithLimits(object.Limits{MaxSteps: 1000000}),
+			)
+
+			// インタプリタごとの組み込み関数が、ほかのインタプリタに漏れないか
+			if err := in.RegisterFunc("workerID", func() int { return id }, ""); err != nil {
+				t.Fatal(err)
+			}
+			if id%2 == 0 {
+				in.HideBuiltin("len")
+			}
+
+			if err := in.Set("id", id); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, script := range parallelScripts {
+				got, err := in.Eval(context.Background(), script.src)
+				if err != nil {
+					t.Fatalf("%s: %v", script.name, err)
+				}
+
+				want := script.expected
+				switch script.name {
+				case "ハッシュとJSON":
+					want = fmt.Sprintf(`{"name":"monkey","n":%d}`, id)
+				case "spawn とチャネル":
+					want = int64(15 * id)
+				}
+
+				if want != nil && !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: want=%#v, got=%#v", script.name, want, got)
+				}
+			}
+
+			if stdout.String() != fmt.Sprintf("%d\ndone", id) {
+				t.Errorf("output of another interpreter is mixed in: %q", stdout.String())
+			}
+
+			got, err := in.Eval(context.Background(), "workerID()")
+			if err != nil || got != int64(id) {
+				t.Errorf("workerID: want=%d, got=%v (%v)", id, got, err)
+			}
+
+			_, err = in.Eval(context.Background(), `len("abc")`)
+			if hidden := id%2 == 0; hidden != (err != nil) {
+				t.Errorf("len should be hidden only in even workers: %v", err)
+			}
+		})
+	}
+}
+
+// 構文解析のトレースは構文解析器ごとに持つので、同時に解析しても混ざらない
+func TestParallelParserTrace(t *testing.T) {
+	const src = "let x = 1 + 2 * 3;"
+
+	trace := func() string {
+		var out bytes.Buffer
+		in := New(WithParserOptions(parser.WithTracer(&out)))
+		if _, err := in.Eval(context.Background(), src); err != nil {
+			t.Error(err)
+		}
+		return out.String()
+	}
+
+	want := trace()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := trace(); got != want {
+				t.Errorf("trace differs when parsing in parallel:\n%s", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// 1つの Interpreter を複数のゴルーチンから使っても壊れない
+func TestSharedInterpreter(t *testing.T) {
+	in := New()
+	if _, err := in.Eval(context.Background(), "let add = fn(a, b) { a + b };"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("v%s", strings.Repeat("x", i))
+			if err := in.Set(name, i); err != nil {
+				t.Error(err)
+				return
+			}
+
+			got, err := in.Eval(context.Background(), fmt.Sprintf("add(%s, 1)", name))
+			if err != nil || got != int64(i+1) {
+				t.Errorf("want=%d, got=%v (%v)", i+1, got, err)
+			}
+
+			got, err = in.Call("add", i, i)
+			if err != nil || got != int64(2*i) {
+				t.Errorf("want=%d, got=%v (%v)", 2*i, got, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// 同時に評価しても、先に始めた評価が終わって取り消されたことで、あとの評価が止まらない
+func TestConcurrentEval(t *testing.T) {
+	firstStarted, secondStarted := make(chan struct{}), make(chan struct{})
+	releaseFirst, releaseSecond := make(chan struct{}), make(chan struct{})
+
+	in := New()
+	if err := in.RegisterFunc("first", func() { close(firstStarted); <-releaseFirst }, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.RegisterFunc("second", func() { close(secondStarted); <-releaseSecond }, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan error)
+	go func() {
+		_, err := in.Eval(ctx, "first()")
+		firstDone <- err
+	}()
+	<-firstStarted
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	secondDone := make(chan result)
+	go func() {
+		v, err := in.Eval(context.Background(), "second(); let f = fn(n) { if (n > 0) { f(n - 1) } else { n } }; f(10)")
+		secondDone <- result{v, err}
+	}()
+	<-secondStarted
+
+	close(releaseFirst)
+	if err := <-firstDone; err != nil {
+		t.Fatalf("first evaluation failed: %v", err)
+	}
+	cancel()
+
+	close(releaseSecond)
+	if r := <-secondDone; r.err != nil || r.value != int64(0) {
+		t.Errorf("second evaluation should not be stopped by the first: got=%v (%v)", r.value, r.err)
+	}
+}
+
+// Eval から戻ったら、評価中に動かし始めたゴルーチンは残らない
+func TestEvalStopsGoroutines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"受け取れない recv", `map(range(100), fn(i) { spawn(fn() { recv(channel()) }) }); 1`},
+		{"受け取られない send", `map(range(100), fn(i) { spawn(fn() { send(channel(), i) }) }); 1`},
+		{"途中で使われなくなったジェネレータ", `let nat = fn*() { let loop = fn(i) { yield i; loop(i + 1) }; loop(0) }; map(range(100), fn(i) { next(nat()) }); 1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+
+			if _, err := New().Eval(context.Background(), tt.input); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > before {
+				if time.Now().After(deadline) {
+					t.Fatalf("goroutines leaked after Eval returned: before=%d, after=%d", before, runtime.NumGoroutine())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// Go の関数から同じ Interpreter を呼び出しても、外側の評価は続けられる
+func TestReentrantCall(t *testing.T) {
+	in := New(WithLimits(object.Limits{MaxSteps: 100000}))
+
+	err := in.RegisterFunc("twice", func(name string, x int) (interface{}, error) {
+		once, err := in.Call(name, x)
+		if err != nil {
+			return nil, err
+		}
+		return in.Call(name, once)
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := in.Eval(context.Background(), `let inc = fn(x) { x + 1 }; twice("inc", 1) + twice("inc", 10)`)
+	if err != nil || got != int64(15) {
+		t.Errorf("want=15, got=%v (%v)", got, err)
+	}
+
+	// 内側の Call が終わっても、外側の評価のコンテキストは残っている
+	in = New()
+	if err := in.RegisterFunc("call", func(name string) (interface{}, error) { return in.Call(name) }, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = in.Eval(ctx, `let one = fn() { 1 }; call("one"); let loop = fn() { loop() }; loop()`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("outer evaluation should be interrupted after a nested call: %v", err)
+	}
+}
@@ -1,34 +1,78 @@
 package lexer
 
 import (
+	"bufio"
+	"bytes"
 	"go-monkey-shakyo/monkey/token"
+	"io"
+	"strings"
 )
 
+// 入力は bufio.Reader から1バイトずつ読むので、入力全体をメモリに載せる必要はない
+// 先読みは bufio.Reader のバッファを覗いて行う
 type Lexer struct {
-	input        string
-	position     int  // 入力における現在の位置(現在の文字を指し示す)
-	readPosition int  // これから読み込む位置(現在の文字の次)
-	ch           byte // 現在検査中の文字
+	r            *bufio.Reader
+	eof          bool  // 入力の終端まで読んだか
+	err          error // 入力の読み込みで起きたエラー(io.EOF は含まない)
+	position     int   // 入力における現在の位置(現在の文字を指し示す)
+	readPosition int   // これから読み込む位置(現在の文字の次)
+	ch           byte  // 現在検査中の文字
+
+	// 現在検査中の文字の行と列(エラー表示用)
+	line   int
+	column int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewReader(strings.NewReader(input))
+}
+
+// r から読みながら字句解析する
+// 大きなファイルやパイプからの入力を、全体を読み込まずに扱える
+func NewReader(r io.Reader) *Lexer {
+	l := &Lexer{r: bufio.NewReader(r), line: 1}
 	l.readChar()
 	return l
 }
 
-// 次の1文字を読んでinput文字列の現在位置をすすめる
+// 入力の読み込みで起きたエラー
+// エラーが起きた時点で入力の終端として扱うので、EOF トークンを受け取ったあとに確認する
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// 次の1文字を読んで入力の現在位置をすすめる
 // 注意: ASCII文字対応のみでUnicodeには非対応。バイト列の解析が必要になるからね(詳しくはp.7参照))。
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
+	// 入力の終端(NUL)まで読んだら、それより先には進まない
+	// (閉じていない文字列リテラルのあとで終端を読み越さないように)
+	if l.eof {
+		l.ch = 0
+		return
+	}
+
+	// 改行を読み終えたら次の行に移る
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+	l.column += 1
+
+	ch, err := l.r.ReadByte()
+	if err != nil {
 		// 入力の終端チェック(読み切った場合)
 
 		// byte(0) は ASCIIコードの "NUL"文字
 		// https://play.golang.org/p/6NnGcUgwNBt
 		l.ch = 0
+		l.eof = true
+
+		if err != io.EOF {
+			l.err = err
+		}
 	} else {
 		// 次の文字を読み込む
-		l.ch = l.input[l.readPosition]
+		l.ch = ch
 	}
 
 	// 検査対象の文字の位置を進める
@@ -43,6 +87,9 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.skipWhitespace()
 
+	// トークンの先頭の位置を覚えておく
+	pos := token.Position{Offset: l.position, Line: l.line, Column: l.column}
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -105,11 +152,13 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Pos = pos
 
 			return tok
 		} else {
@@ -117,6 +166,8 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	}
 
+	tok.Pos = pos
+
 	// トークンを返す前に次の文字に進める
 	l.readChar()
 
@@ -128,10 +179,15 @@ func (l *Lexer) NextToken() token.Token {
 // MEMO: 言語におけるパースの難易度の違いは、ソースコードを解釈する際に、
 //       どの程度先まで読む（もしくは戻って読む！）必要があるかによるところが大きい。
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	if l.eof {
+		return 0
+	}
+
+	next, err := l.r.Peek(1)
+	if err != nil {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		return next[0]
 	}
 }
 
@@ -143,25 +199,27 @@ func isDigit(ch byte) bool {
 // 浮動小数点数も16進数も扱えない
 
 func (l *Lexer) readNumber() string {
-	position := l.position
+	var out bytes.Buffer
 
 	// 数字である限り読みすすめる
 	for isDigit(l.ch) {
+		out.WriteByte(l.ch)
 		l.readChar()
 	}
 
-	return l.input[position:l.position]
+	return out.String()
 }
 
 func (l *Lexer) readIdentifier() string {
-	position := l.position
+	var out bytes.Buffer
 
 	// 英字を区切りまで読み進める
 	for isLetter(l.ch) {
+		out.WriteByte(l.ch)
 		l.readChar()
 	}
 
-	return l.input[position:l.position]
+	return out.String()
 }
 
 // どういう文字を読み飛ばすかを決める
@@ -187,14 +245,16 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 
 // 閉じ二重引用符か入力の最後に至るまで readChar を呼ぶ。
 func (l *Lexer) readString() string {
-	position := l.position + 1
+	var out bytes.Buffer
 
 	for {
 		l.readChar()
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
+
+		out.WriteByte(l.ch)
 	}
 
-	return l.input[position:l.position]
+	return out.String()
 }
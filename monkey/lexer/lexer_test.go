@@ -1,8 +1,13 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
 	"go-monkey-shakyo/monkey/token"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNextToken(t *testing.T) {
@@ -175,3 +180,161 @@ if (5 < 10) {
 		}
 	}
 }
+
+// トークンの先頭の位置(行と列)を記録できること
+func TestTokenPosition(t *testing.T) {
+	input := `let x = 5;
+  "foo" == y;
+`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+		expectedOffset int
+	}{
+		{token.LET, 1, 1, 0},
+		{token.IDENT, 1, 5, 4},
+		{token.ASSIGN, 1, 7, 6},
+		{token.INT, 1, 9, 8},
+		{token.SEMICOLON, 1, 10, 9},
+		{token.STRING, 2, 3, 13},
+		{token.EQ, 2, 9, 19},
+		{token.IDENT, 2, 12, 22},
+		{token.SEMICOLON, 2, 13, 23},
+		{token.EOF, 3, 1, 25},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+
+		if tok.Pos.Offset != tt.expectedOffset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d, got=%d", i, tt.expectedOffset, tok.Pos.Offset)
+		}
+	}
+}
+
+// 任意の入力に対して、字句解析器がパニックせずに必ず EOF まで到達すること
+func FuzzNextToken(f *testing.F) {
+	// 既存のテストの入力を種にする
+	seeds := []string{
+		"let five = 5;\nlet ten = 10;\n",
+		"let add = fn(x, y) {\n  x + y;\n}\n",
+		"let result = add(five, ten);\n!-/*5;\n5 < 10 > 5;",
+		"if (5 < 10) {\n    return true;\n} else {\n    return false;\n}",
+		"10 == 10;\n10 != 9;",
+		`"foobar" "foo bar"`,
+		`[1, 2]; {"foo": "bar"}`,
+		`"unterminated`,
+		"\t\r\n\x00\xff",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := New(input)
+
+		lastOffset := -1
+
+		// 1文字から1つ以上のトークンが作られることはないので、入力の長さ+1回で EOF に着くはず
+		for i := 0; i <= len(input); i++ {
+			tok := l.NextToken()
+
+			if tok.Pos.Offset < lastOffset || tok.Pos.Offset > len(input) {
+				t.Fatalf("token offset out of order. last=%d, got=%d (%q)", lastOffset, tok.Pos.Offset, tok.Literal)
+			}
+			lastOffset = tok.Pos.Offset
+
+			if tok.Type == token.IDENT || tok.Type == token.INT {
+				end := tok.Pos.Offset + len(tok.Literal)
+				if end > len(input) || input[tok.Pos.Offset:end] != tok.Literal {
+					t.Fatalf("literal does not match input at %d. got=%q", tok.Pos.Offset, tok.Literal)
+				}
+			}
+
+			if tok.Type == token.EOF {
+				return
+			}
+		}
+
+		t.Fatalf("lexer did not reach EOF after %d tokens", len(input)+1)
+	})
+}
+
+// io.Reader から読んでも、文字列から読んだときと同じトークンが得られること
+func TestNewReader(t *testing.T) {
+	var large strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&large, "let x%d = fn(a, b) { a * %d + \"s%d\" };\n", i, i, i)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"空の入力", ""},
+		{"2文字の演算子", "a == b != !c"},
+		{"改行を含む", "let x = 5;\n\nlet y = [1, 2];\n"},
+		{"閉じていない文字列", `"unterminated`},
+		{"NULを含む", "1\x002"},
+		{"bufioのバッファより大きな入力", large.String()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := New(tt.input)
+
+			// 1バイトずつしか返さない Reader でも同じになる
+			l := NewReader(iotest.OneByteReader(strings.NewReader(tt.input)))
+
+			for i := 0; ; i++ {
+				want := expected.NextToken()
+				got := l.NextToken()
+
+				if got != want {
+					t.Fatalf("tokens[%d] wrong. want=%+v, got=%+v", i, want, got)
+				}
+
+				if want.Type == token.EOF {
+					break
+				}
+			}
+
+			if l.Err() != nil {
+				t.Errorf("l.Err() should be nil. got=%v", l.Err())
+			}
+		})
+	}
+}
+
+// 読み込みに失敗したら、そこを入力の終端として扱ってエラーを報告する
+func TestNewReaderError(t *testing.T) {
+	readErr := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(readErr))
+
+	l := NewReader(r)
+
+	expected := []token.TokenType{token.LET, token.IDENT, token.EOF}
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tokens[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+
+	if l.Err() != readErr {
+		t.Errorf("l.Err() wrong. expected=%v, got=%v", readErr, l.Err())
+	}
+}
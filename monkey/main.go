@@ -1,13 +1,48 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"go-monkey-shakyo/monkey/evaluator"
+	"go-monkey-shakyo/monkey/lexer"
+	"go-monkey-shakyo/monkey/object"
+	"go-monkey-shakyo/monkey/parser"
 	"go-monkey-shakyo/monkey/repl"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"os/user"
+	"path/filepath"
+)
+
+var (
+	traceParse  = flag.Bool("trace-parse", false, "print a BEGIN/END trace of the parser to stderr")
+	strictIndex = flag.Bool("strict-index", false, "make out-of-range indexes and missing hash keys an error instead of null")
+	fileRoot    = flag.String("file-root", "", "directory that readFile, writeFile and the other file builtins may access (disabled if empty)")
+	allow       = flag.String("allow", "all", "comma-separated capabilities granted to scripts: stdout, filesystem, clock, random, env, modules, all or none")
+	timeout     = flag.Duration("timeout", 0, "stop running a script file after this long, e.g. 5s (no limit if 0)")
 )
 
 func main() {
+	flag.Parse()
+
+	capabilities, err := object.ParseCapabilities(*allow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var opts []parser.Option
+	if *traceParse {
+		opts = append(opts, parser.WithTracer(os.Stderr))
+	}
+
+	// ファイルが指定されたらそれを実行する。指定がなければREPLを起動する
+	if flag.NArg() > 0 {
+		os.Exit(runFile(flag.Arg(0), capabilities, opts))
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -16,5 +51,67 @@ func main() {
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
 
-	repl.Start(os.Stdin, os.Stdout)
+	env := object.NewEnvironment()
+	env.Settings().StrictIndex = *strictIndex
+	env.Settings().FileRoot = *fileRoot
+	env.Settings().Capabilities = capabilities
+	env.Settings().Stdout = os.Stdout
+	env.Settings().Stderr = os.Stderr
+
+	repl.Run(os.Stdin, os.Stdout, env, opts...)
+}
+
+// Monkeyのソースファイルを実行して、終了コードを返す
+func runFile(path string, capabilities object.Capabilities, opts []parser.Option) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	// ファイル全体を読み込まずに、読みながら字句解析する
+	l := lexer.NewReader(f)
+	p := parser.New(l, opts...)
+
+	program := p.ParseProgram()
+	if err := l.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(p.Diagnostics()) != 0 {
+		// エラーの行を表示するために、ここで初めてソース全体を読む
+		src, _ := ioutil.ReadFile(path)
+		for _, d := range p.Diagnostics() {
+			fmt.Fprint(os.Stderr, path+":"+d.Render(string(src)))
+		}
+		return 1
+	}
+
+	// import はスクリプトのあるディレクトリ、MONKEYPATH の順に探す
+	env := object.NewEnvironment()
+	env.SetDir(filepath.Dir(path))
+	env.Modules().SearchPath = filepath.SplitList(os.Getenv("MONKEYPATH"))
+	env.Settings().StrictIndex = *strictIndex
+	env.Settings().FileRoot = *fileRoot
+	env.Settings().Capabilities = capabilities
+
+	// Ctrl-C (SIGINT) か --timeout で評価を止める
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	env.SetContext(ctx)
+
+	evaluated := evaluator.Eval(program, env)
+	if evaluated != nil && evaluated.Type() == object.ERROR_OBJ {
+		fmt.Fprintln(os.Stderr, evaluated.Inspect())
+		return 1
+	}
+
+	return 0
 }
@@ -0,0 +1,66 @@
+package object
+
+import "sync"
+
+// 環境の木ごとに、組み込み関数を追加・上書き・隠すための登録簿
+// どの環境でも使える標準の組み込み関数は評価器が持ち、ここにはそれとの違いだけを持つ
+// 評価中にも読めるように、ロックして読み書きする
+type BuiltinRegistry struct {
+	mu      sync.RWMutex
+	defined map[string]*Builtin
+	hidden  map[string]bool
+}
+
+func NewBuiltinRegistry() *BuiltinRegistry {
+	return &BuiltinRegistry{
+		defined: make(map[string]*Builtin),
+		hidden:  make(map[string]bool),
+	}
+}
+
+// b.Name の名前で組み込み関数を追加する。同じ名前の標準の組み込み関数は上書きされる
+func (r *BuiltinRegistry) Register(b *Builtin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.defined[b.Name] = b
+	delete(r.hidden, b.Name)
+}
+
+// name の組み込み関数を使えなくする(追加したものも、標準のものも)
+func (r *BuiltinRegistry) Hide(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.defined, name)
+	r.hidden[name] = true
+}
+
+// 追加した組み込み関数
+func (r *BuiltinRegistry) Get(name string) (*Builtin, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	b, ok := r.defined[name]
+	return b, ok
+}
+
+func (r *BuiltinRegistry) IsHidden(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.hidden[name]
+}
+
+// 追加した組み込み関数すべて
+func (r *BuiltinRegistry) Defined() []*Builtin {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var defined []*Builtin
+	for _, b := range r.defined {
+		defined = append(defined, b)
+	}
+
+	return defined
+}
@@ -0,0 +1,83 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 組み込み関数が外の世界に触れるための権限
+// 組み込む側が許した権限を使う組み込み関数だけが動くので、信頼できないスクリプトも安全に評価できる
+type Capability string
+
+const (
+	CapStdout     Capability = "stdout"     // 標準出力と標準エラー出力に書く(puts, print, eprint)
+	CapFilesystem Capability = "filesystem" // ファイルを読み書きする(readFile など。FileRoot の設定も必要)
+	CapClock      Capability = "clock"      // 現在時刻を読む(now)
+	CapRandom     Capability = "random"     // 乱数を使う(random)
+	CapEnv        Capability = "env"        // 環境変数を読む(getenv)
+	CapModules    Capability = "modules"    // モジュールを読み込む(import。起点のディレクトリと検索パスの中だけ)
+)
+
+// すべての権限
+var AllCapabilities = []Capability{CapStdout, CapFilesystem, CapClock, CapRandom, CapEnv, CapModules}
+
+// 許した権限の集まり
+type Capabilities map[Capability]bool
+
+func NewCapabilities(caps ...Capability) Capabilities {
+	c := make(Capabilities, len(caps))
+	for _, capability := range caps {
+		c[capability] = true
+	}
+
+	return c
+}
+
+// "stdout,clock" のようなカンマ区切りの権限の一覧を読む
+// "all" はすべての権限、"none" や空文字列は権限なし
+func ParseCapabilities(s string) (Capabilities, error) {
+	c := NewCapabilities()
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "none":
+			continue
+		case "all":
+			for _, capability := range AllCapabilities {
+				c[capability] = true
+			}
+			continue
+		}
+
+		capability := Capability(name)
+		if !capability.valid() {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+		c[capability] = true
+	}
+
+	return c, nil
+}
+
+func (c Capabilities) String() string {
+	var names []string
+	for capability, granted := range c {
+		if granted {
+			names = append(names, string(capability))
+		}
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ",")
+}
+
+func (c Capability) valid() bool {
+	for _, known := range AllCapabilities {
+		if c == known {
+			return true
+		}
+	}
+
+	return false
+}
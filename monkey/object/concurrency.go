@@ -0,0 +1,135 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// spawn で別のゴルーチンで動かしている関数
+// 関数が終わると Done() が閉じられ、Result() で戻り値(エラーもそのまま)が読めるようになる
+type Task struct {
+	done   chan struct{}
+	result Object
+}
+
+func NewTask() *Task {
+	return &Task{done: make(chan struct{})}
+}
+
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+func (t *Task) Inspect() string {
+	select {
+	case <-t.done:
+		return "task(done)"
+	default:
+		return "task(running)"
+	}
+}
+
+// 関数の戻り値を記録して、待っている側に終わったことを知らせる。1回だけ呼ぶ
+func (t *Task) Finish(result Object) {
+	t.result = result
+	close(t.done)
+}
+
+func (t *Task) Done() <-chan struct{} {
+	return t.done
+}
+
+// Done() が閉じられる前に呼んではいけない
+func (t *Task) Result() Object {
+	return t.result
+}
+
+// ゴルーチンの間で値を受け渡すチャネル
+type Channel struct {
+	ch chan Object
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// size は送った値を受け取られる前にためておける数。0 なら受け取られるまで送る側が待つ
+func NewChannel(size int) *Channel {
+	return &Channel{ch: make(chan Object, size)}
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string {
+	return fmt.Sprintf("channel(%d/%d)", len(c.ch), cap(c.ch))
+}
+
+// 送受信に使う Go のチャネル
+// 閉じたチャネルに送ると Go と同じく panic するので、送る側は recover する
+func (c *Channel) Chan() chan Object {
+	return c.ch
+}
+
+// すでに閉じていればエラーを返す
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errors.New("close of closed channel")
+	}
+
+	c.closed = true
+	close(c.ch)
+	return nil
+}
+
+// いくつかのゴルーチンが終わるのを待つためのカウンタ
+// sync.WaitGroup と違って、待つのをコンテキストの取り消しと一緒に select できる
+type WaitGroup struct {
+	mu    sync.Mutex
+	count int
+	zero  chan struct{} // count が 0 になったら閉じる
+}
+
+func NewWaitGroup() *WaitGroup {
+	zero := make(chan struct{})
+	close(zero)
+
+	return &WaitGroup{zero: zero}
+}
+
+func (wg *WaitGroup) Type() ObjectType { return WAIT_GROUP_OBJ }
+func (wg *WaitGroup) Inspect() string {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	return fmt.Sprintf("waitGroup(%d)", wg.count)
+}
+
+// カウンタに n を足す(負なら引く)
+// 0 より小さくなるならエラーを返し、カウンタは変えない
+func (wg *WaitGroup) Add(n int) error {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	if wg.count+n < 0 {
+		return errors.New("negative wait group counter")
+	}
+
+	if wg.count == 0 && n > 0 {
+		wg.zero = make(chan struct{})
+	}
+
+	wg.count += n
+
+	if wg.count == 0 && n < 0 {
+		close(wg.zero)
+	}
+
+	return nil
+}
+
+// カウンタが 0 になったら閉じられるチャネル
+func (wg *WaitGroup) Wait() <-chan struct{} {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	return wg.zero
+}
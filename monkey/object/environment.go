@@ -1,17 +1,54 @@
 package object
 
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s}
+	return &Environment{mu: &sync.RWMutex{}, store: s, usage: &usage{}, modules: NewModuleRegistry(), settings: &Settings{}, builtins: NewBuiltinRegistry()}
 }
 
+// 環境は spawn した関数から同時に読み書きされることがあるので、束縛はロックして読み書きする
+// (NewEvaluation で作った環境とは束縛もロックも共有する)
 type Environment struct {
+	mu    *sync.RWMutex
 	store map[string]Object
 	outer *Environment
+
+	// 以下は外側の環境と共有する
+	// ただし usage は評価ごとのもので、関数を呼び出すときは呼び出した側のものを使う
+	usage    *usage
+	modules  *ModuleRegistry
+	settings *Settings
+	builtins *BuiltinRegistry
+
+	// 評価しているソースのファイルがあるディレクトリ(import の起点)
+	// 空ならカレントディレクトリ
+	dir string
+
+	// 最初の起点のディレクトリ。検索パスのほかには、この中のモジュールだけを import できる
+	// import したモジュールの環境も、読み込んだ側のものを受け継ぐ
+	root string
+
+	// この環境を作るまでに読み込みを始めたモジュールのパスの並び(import の循環の検出用)
+	importing []string
+
+	// 関数呼び出しの深さ(呼び出しで作った環境は、呼び出した側の環境より1つ深い)
+	depth int
+
+	// yield 文で値を渡す先(一番近いジェネレータの本体)。ジェネレータの外では nil
+	// 外側の環境からは受け継がず、関数を呼び出すときに呼び出した側から受け継ぐ
+	yield func(Object) bool
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
+	e.mu.RLock()
 	obj, ok := e.store[name]
+	e.mu.RUnlock()
 
 	// 現在の「環境」には存在しないが、「外の環境」にはあるかもしれないので探しに行く
 	if !ok && e.outer != nil {
@@ -22,7 +59,10 @@ func (e *Environment) Get(name string) (Object, bool) {
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+	e.mu.Lock()
 	e.store[name] = val
+	e.mu.Unlock()
+
 	return val
 }
 
@@ -30,7 +70,196 @@ func (e *Environment) Set(name string, val Object) Object {
 // http://ejbridge2.blog.fc2.com/blog-entry-146.html
 // https://res.cloudinary.com/dyd911kmh/image/upload/f_auto,q_auto:best/v1588956604/Scope_fbrzcw.png
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	return &Environment{
+		mu:        &sync.RWMutex{},
+		store:     make(map[string]Object),
+		outer:     outer,
+		usage:     outer.usage,
+		modules:   outer.modules,
+		settings:  outer.settings,
+		builtins:  outer.builtins,
+		dir:       outer.dir,
+		root:      outer.root,
+		importing: outer.importing,
+		depth:     outer.depth,
+	}
+}
+
+// path のモジュールを評価するための環境
+// 束縛は共有しないが、資源の上限やモジュールの登録簿、設定、組み込み関数は import する側と共有する
+func NewModuleEnvironment(importer *Environment, path string) *Environment {
+	importing := make([]string, 0, len(importer.importing)+1)
+	importing = append(importing, importer.importing...)
+
+	return &Environment{
+		mu:        &sync.RWMutex{},
+		store:     make(map[string]Object),
+		usage:     importer.usage,
+		modules:   importer.modules,
+		settings:  importer.settings,
+		builtins:  importer.builtins,
+		dir:       filepath.Dir(path),
+		root:      importer.root,
+		importing: append(importing, path),
+		depth:     importer.depth,
+	}
+}
+
+// e と同じ束縛を読み書きする、1回の評価のための環境
+// 資源の上限は e と同じだが、使った量は 0 から数え、ctx が取り消されたり期限が過ぎたりすると評価が止まる
+// 同じ環境で同時に評価しても、使った量やコンテキストが混ざらないように、評価ごとに作る
+func (e *Environment) NewEvaluation(ctx context.Context) *Environment {
+	return &Environment{
+		mu:        e.mu,
+		store:     e.store,
+		outer:     e.outer,
+		usage:     &usage{limits: e.usage.limits, ctx: ctx},
+		modules:   e.modules,
+		settings:  e.settings,
+		builtins:  e.builtins,
+		dir:       e.dir,
+		root:      e.root,
+		importing: e.importing,
+		depth:     e.depth,
+		yield:     e.yield,
+	}
+}
+
+func (e *Environment) Modules() *ModuleRegistry {
+	return e.modules
+}
+
+// この環境の木で追加・上書き・隠した組み込み関数
+func (e *Environment) Builtins() *BuiltinRegistry {
+	return e.builtins
+}
+
+func (e *Environment) Settings() *Settings {
+	return e.settings
+}
+
+func (e *Environment) Dir() string {
+	return e.dir
+}
+
+// import の起点となるディレクトリを設定する
+// 検索パスのほかには、このディレクトリの中のモジュールだけを import できるようになる
+func (e *Environment) SetDir(dir string) {
+	e.dir = dir
+	e.root = dir
+}
+
+// import できるモジュールを置くディレクトリ(検索パスのほか)
+// 空ならカレントディレクトリ
+func (e *Environment) ModuleRoot() string {
+	return e.root
+}
+
+// 資源の上限を設定して、これまでに使った量を 0 に戻す
+// 評価していないときに呼ぶ
+func (e *Environment) SetLimits(limits Limits) {
+	e.usage.limits = limits
+	e.ResetUsage()
+}
+
+func (e *Environment) Limits() Limits {
+	return e.usage.limits
+}
+
+// 上限はそのままで、これまでに使った量を 0 に戻す
+func (e *Environment) ResetUsage() {
+	atomic.StoreInt64(&e.usage.steps, 0)
+	atomic.StoreInt64(&e.usage.alloc, 0)
+}
+
+// ノードを1つ評価するごとに呼ぶ
+// ステップ数の上限を超えたら false を返す
+func (e *Environment) Step() bool {
+	steps := atomic.AddInt64(&e.usage.steps, 1)
+
+	max := e.usage.limits.MaxSteps
+	return max == 0 || steps <= int64(max)
+}
+
+// 関数呼び出しの深さ
+func (e *Environment) Depth() int {
+	return e.depth
+}
+
+// 関数を呼び出すときに作った環境に、呼び出した側の環境より1つ深いことを記録する
+func (e *Environment) SetDepth(depth int) {
+	e.depth = depth
+}
+
+// 関数を呼び出すときに作った環境で、呼び出した側の評価の資源の上限と使った量、コンテキストを使う
+// 前の評価で定義した関数も、呼び出した評価の中で数え、その評価と一緒に止まる
+func (e *Environment) ShareUsage(caller *Environment) {
+	e.usage = caller.usage
+}
+
+// yield 文で値を渡す先
+func (e *Environment) Yield() func(Object) bool {
+	return e.yield
+}
+
+// ジェネレータの本体や、そこから呼び出した関数を評価する環境に、yield 文で値を渡す先を設定する
+func (e *Environment) SetYield(yield func(Object) bool) {
+	e.yield = yield
+}
+
+// この環境を作るまでに読み込みを始めたモジュールのパスの並び
+func (e *Environment) Importing() []string {
+	return e.importing
+}
+
+// 値を作るときに、そのおおよそのバイト数を足す
+// 上限を超えたら false を返す
+func (e *Environment) Allocate(bytes int) bool {
+	alloc := atomic.AddInt64(&e.usage.alloc, int64(bytes))
+
+	max := e.usage.limits.MaxAlloc
+	return max == 0 || alloc <= int64(max)
+}
+
+// あと bytes バイトの値を作っても上限を超えないか(使った量には足さない)
+func (e *Environment) CanAllocate(bytes int) bool {
+	max := e.usage.limits.MaxAlloc
+	// bytes が大きくても桁あふれしないように、残りの量と比べる
+	return max == 0 || int64(bytes) <= int64(max)-atomic.LoadInt64(&e.usage.alloc)
+}
+
+// 評価を途中で止めるためのコンテキストを設定する
+// 取り消されたり期限が過ぎたりすると、次の文か関数呼び出しで評価が止まる
+func (e *Environment) SetContext(ctx context.Context) {
+	e.usage.mu.Lock()
+	e.usage.ctx = ctx
+	e.usage.mu.Unlock()
+}
+
+// 評価を止めるべきなら、その理由(context.Canceled など)を返す
+func (e *Environment) Interrupted() error {
+	ctx := e.context()
+	if ctx == nil {
+		return nil
+	}
+
+	return ctx.Err()
+}
+
+// 評価を止めるべきときに閉じられるチャネル
+// 止める手段がなければ nil(select で待っても選ばれない)
+func (e *Environment) Done() <-chan struct{} {
+	ctx := e.context()
+	if ctx == nil {
+		return nil
+	}
+
+	return ctx.Done()
+}
+
+func (e *Environment) context() context.Context {
+	e.usage.mu.RLock()
+	defer e.usage.mu.RUnlock()
+
+	return e.usage.ctx
 }
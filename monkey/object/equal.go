@@ -0,0 +1,110 @@
+package object
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// 構造を比べて等しいか(== と != で使う)
+// 配列は同じ順番で要素が等しければ、ハッシュは同じキーに等しい値があれば(順番は問わない)等しい
+// 関数のように値として比べられないものは、同じオブジェクトのときだけ等しい
+func Equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *NULL:
+		return b.Type() == NULL_OBJ
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+
+		for i := range a.Elements {
+			if !Equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+
+		for _, pair := range a.Pairs() {
+			other, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !Equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return a == b
+	}
+}
+
+// obj をハッシュのキーとして使えるなら Hashable として返す
+// 配列とハッシュは、中身もすべてキーとして使えるときだけキーにできる
+func AsHashable(obj Object) (Hashable, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		for _, el := range obj.Elements {
+			if _, ok := AsHashable(el); !ok {
+				return nil, false
+			}
+		}
+	case *Hash:
+		// キーは追加するときに確かめているので、値だけ確かめる
+		for _, pair := range obj.Pairs() {
+			if _, ok := AsHashable(pair.Value); !ok {
+				return nil, false
+			}
+		}
+	}
+
+	h, ok := obj.(Hashable)
+	return h, ok
+}
+
+// 要素のハッシュ値を順番どおりに混ぜる
+// 中身がキーとして使えるかは AsHashable で確かめておくこと
+func (ao *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	for _, el := range ao.Elements {
+		writeHashKey(h, el.(Hashable).HashKey())
+	}
+
+	return HashKey{Type: ao.Type(), Value: h.Sum64()}
+}
+
+// ペアの順番によらないように、ペアごとのハッシュ値を足し合わせる
+// 中身がキーとして使えるかは AsHashable で確かめておくこと
+func (h *Hash) HashKey() HashKey {
+	var sum uint64
+	for _, pair := range h.Pairs() {
+		ph := fnv.New64a()
+		writeHashKey(ph, pair.Key.(Hashable).HashKey())
+		writeHashKey(ph, pair.Value.(Hashable).HashKey())
+		sum += ph.Sum64()
+	}
+
+	return HashKey{Type: h.Type(), Value: sum}
+}
+
+func writeHashKey(w interface{ Write([]byte) (int, error) }, key HashKey) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], key.Value)
+
+	w.Write([]byte(key.Type))
+	w.Write(buf[:])
+}
@@ -0,0 +1,163 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// キーを追加した順番を覚えているハッシュ
+// 表示も列挙もいつも追加した順になるので、スクリプトの出力を実行ごとに比べられる
+//
+// ペアは追加した順に entries に並べ、index でハッシュ値から entries の位置を引く
+// 違うキーのハッシュ値が衝突することもあるので、index にはハッシュ値ごとに位置の一覧(バケット)を持ち、
+// その中からキーの値が等しいものを探す
+// 削除したところは nil にしておき(O(1))、nil が半分を超えたら詰め直す
+type Hash struct {
+	index   map[HashKey][]int
+	entries []*HashPair
+	length  int
+
+	// キーのハッシュ値を計算する関数。nil ならキーの HashKey() を使う
+	// テストでハッシュ値の衝突を起こすために、ハッシュごとに差し替えられるようにしている
+	hashKey func(Hashable) HashKey
+}
+
+func NewHash() *Hash {
+	return newHashWith(nil)
+}
+
+func newHashWith(hashKey func(Hashable) HashKey) *Hash {
+	return &Hash{index: make(map[HashKey][]int), hashKey: hashKey}
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	var pairs []string
+
+	for _, pair := range h.Pairs() {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (h *Hash) Get(key Hashable) (HashPair, bool) {
+	i, ok := h.find(key)
+	if !ok {
+		return HashPair{}, false
+	}
+
+	return *h.entries[i], true
+}
+
+// キーがすでにあれば値を置き換える。順番は最初に追加したときのまま
+func (h *Hash) Set(key Hashable, value Object) {
+	if i, ok := h.find(key); ok {
+		h.entries[i].Value = value
+		return
+	}
+
+	hashed := h.keyOf(key)
+	h.index[hashed] = append(h.index[hashed], len(h.entries))
+	h.entries = append(h.entries, &HashPair{Key: key, Value: value})
+	h.length++
+}
+
+func (h *Hash) Delete(key Hashable) {
+	i, ok := h.find(key)
+	if !ok {
+		return
+	}
+
+	hashed := h.keyOf(key)
+	bucket := h.index[hashed]
+	for j, idx := range bucket {
+		if idx == i {
+			bucket = append(bucket[:j:j], bucket[j+1:]...)
+			break
+		}
+	}
+
+	if len(bucket) == 0 {
+		delete(h.index, hashed)
+	} else {
+		h.index[hashed] = bucket
+	}
+
+	h.entries[i] = nil
+	h.length--
+
+	if h.length < len(h.entries)/2 {
+		h.compact()
+	}
+}
+
+func (h *Hash) Len() int {
+	return h.length
+}
+
+// 追加した順に並べたペア
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, 0, h.length)
+	for _, entry := range h.entries {
+		if entry != nil {
+			pairs = append(pairs, *entry)
+		}
+	}
+
+	return pairs
+}
+
+// 同じペアを同じ順番で持つ別のハッシュ
+func (h *Hash) Copy() *Hash {
+	newHash := newHashWith(h.hashKey)
+	for _, entry := range h.entries {
+		if entry != nil {
+			newHash.Set(entry.Key.(Hashable), entry.Value)
+		}
+	}
+
+	return newHash
+}
+
+// key と等しいキーを持つペアの entries での位置
+func (h *Hash) find(key Hashable) (int, bool) {
+	for _, i := range h.index[h.keyOf(key)] {
+		if Equal(h.entries[i].Key, key) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func (h *Hash) keyOf(key Hashable) HashKey {
+	if h.hashKey != nil {
+		return h.hashKey(key)
+	}
+
+	return key.HashKey()
+}
+
+// 削除で空いたところを詰めて、index を作り直す
+func (h *Hash) compact() {
+	entries := make([]*HashPair, 0, h.length)
+	index := make(map[HashKey][]int, h.length)
+	for _, entry := range h.entries {
+		if entry != nil {
+			hashed := h.keyOf(entry.Key.(Hashable))
+			index[hashed] = append(index[hashed], len(entries))
+			entries = append(entries, entry)
+		}
+	}
+
+	h.entries = entries
+	h.index = index
+}
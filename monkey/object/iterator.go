@@ -0,0 +1,295 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 値を1つずつ取り出せるオブジェクト
+// 配列・文字列・ハッシュ・範囲の反復子と、ジェネレータが実装している
+//
+// Next は次の値と true を、取り出し終えていたら nil と false を返す
+// ジェネレータの本体がエラーで終わったときは、そのエラーを値として1回だけ返す
+// Done は次の Next で値が取り出せないかどうか(取り出した値は減らない)
+type Iterator interface {
+	Object
+	Next() (Object, bool)
+	Done() bool
+}
+
+// 配列・文字列・ハッシュを先頭から順にたどる反復子
+// 反復子を作ったときの要素をたどるので、途中でもとの値に要素を足しても影響しない
+type SequenceIterator struct {
+	mu     sync.Mutex
+	source ObjectType
+	length int
+	at     func(i int) Object
+	pos    int
+}
+
+func NewArrayIterator(arr *Array) *SequenceIterator {
+	elements := arr.Elements
+	return &SequenceIterator{
+		source: ARRAY_OBJ,
+		length: len(elements),
+		at:     func(i int) Object { return elements[i] },
+	}
+}
+
+// 文字列のインデックスと同じく、1バイトずつの文字列を取り出す
+func NewStringIterator(str *String) *SequenceIterator {
+	value := str.Value
+	return &SequenceIterator{
+		source: STRING_OBJ,
+		length: len(value),
+		at:     func(i int) Object { return &String{Value: value[i : i+1]} },
+	}
+}
+
+// 追加した順に [キー, 値] の配列を取り出す
+func NewHashIterator(hash *Hash) *SequenceIterator {
+	pairs := hash.Pairs()
+	return &SequenceIterator{
+		source: HASH_OBJ,
+		length: len(pairs),
+		at:     func(i int) Object { return &Array{Elements: []Object{pairs[i].Key, pairs[i].Value}} },
+	}
+}
+
+func (it *SequenceIterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *SequenceIterator) Inspect() string {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	return fmt.Sprintf("iterator(%s, %d/%d)", it.source, it.pos, it.length)
+}
+
+func (it *SequenceIterator) Next() (Object, bool) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.pos >= it.length {
+		return nil, false
+	}
+
+	value := it.at(it.pos)
+	it.pos++
+
+	return value, true
+}
+
+func (it *SequenceIterator) Done() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	return it.pos >= it.length
+}
+
+// start から end の手前まで step ずつ進む整数の反復子
+// range と違って配列を作らないので、どんなに長くても大きさは変わらない
+type RangeIterator struct {
+	mu              sync.Mutex
+	next, end, step int64
+	done            bool
+}
+
+// step は 0 であってはいけない
+func NewRangeIterator(start, end, step int64) *RangeIterator {
+	empty := (step > 0 && start >= end) || (step < 0 && start <= end)
+	return &RangeIterator{next: start, end: end, step: step, done: empty}
+}
+
+func (it *RangeIterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *RangeIterator) Inspect() string {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.done {
+		return "iterator(RANGE, done)"
+	}
+	return fmt.Sprintf("iterator(RANGE, %d..%d by %d)", it.next, it.end, it.step)
+}
+
+func (it *RangeIterator) Next() (Object, bool) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.done {
+		return nil, false
+	}
+
+	value := it.next
+
+	// end の近くで step を足すと桁あふれするので、残りの幅と比べてから進める
+	var remaining, stride uint64
+	if it.step > 0 {
+		remaining, stride = uint64(it.end)-uint64(it.next), uint64(it.step)
+	} else {
+		remaining, stride = uint64(it.next)-uint64(it.end), -uint64(it.step)
+	}
+
+	if remaining <= stride {
+		it.done = true
+	} else {
+		it.next += it.step
+	}
+
+	return &Integer{Value: value}, true
+}
+
+func (it *RangeIterator) Done() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	return it.done
+}
+
+// ジェネレータ関数(fn*)を呼び出して作る反復子
+// 本体は別のゴルーチンで動かし、yield するたびに止まって、次の値を求められるまで待つ
+//
+// 本体が yield に渡した値を values で受け取り、resume で続きを動かす
+// 本体が終わると result に戻り値を記録して values を閉じる
+// 途中で使われなくなったジェネレータの本体は、done が閉じられるまで yield で止まったままになる
+type Generator struct {
+	mu      sync.Mutex
+	co      *coroutine
+	started bool
+	running bool // 本体が次の値を作っている最中(本体の中から自分の next を呼ぶと止まってしまうので断る)
+	peeked  Object
+	hasPeek bool
+	done    bool
+}
+
+// 本体のゴルーチンが参照するもの
+type coroutine struct {
+	run    func(yield func(Object) bool) Object
+	done   <-chan struct{}
+	values chan Object
+	resume chan struct{} // 本体が終わったあとに送っても止まらないように、1つだけためておける
+	result Object
+}
+
+// run は本体を最後まで評価して、その結果を返す関数
+// 本体の中で yield(v) を呼ぶと、v を渡して次の値を求められるまで止まる
+// done が閉じられると yield は false を返すので、本体はすぐに終わらなければならない
+// (ジェネレータを作った評価が終わったときに閉じるチャネルを渡す。nil だと本体は止まったまま残ってしまう)
+func NewGenerator(done <-chan struct{}, run func(yield func(Object) bool) Object) *Generator {
+	return &Generator{co: &coroutine{
+		run:    run,
+		done:   done,
+		values: make(chan Object),
+		resume: make(chan struct{}, 1),
+	}}
+}
+
+func (g *Generator) Type() ObjectType { return GENERATOR_OBJ }
+func (g *Generator) Inspect() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case g.running:
+		return "generator(running)"
+	case g.done && !g.hasPeek:
+		return "generator(done)"
+	default:
+		return "generator(suspended)"
+	}
+}
+
+func (g *Generator) Next() (Object, bool) {
+	g.mu.Lock()
+	if g.hasPeek {
+		value := g.peeked
+		g.peeked, g.hasPeek = nil, false
+		g.mu.Unlock()
+		return value, true
+	}
+	g.mu.Unlock()
+
+	return g.fetch()
+}
+
+func (g *Generator) Done() bool {
+	g.mu.Lock()
+	if g.hasPeek {
+		g.mu.Unlock()
+		return false
+	}
+	g.mu.Unlock()
+
+	// 値が残っているかは、本体を次の yield まで動かしてみないとわからない
+	value, ok := g.fetch()
+	if !ok {
+		return true
+	}
+
+	g.mu.Lock()
+	g.peeked, g.hasPeek = value, true
+	g.mu.Unlock()
+
+	return false
+}
+
+// 本体を次の yield か終わりまで動かす
+func (g *Generator) fetch() (Object, bool) {
+	g.mu.Lock()
+	if g.running {
+		g.mu.Unlock()
+		return &Error{Message: "generator is already running"}, true
+	}
+	if g.done {
+		g.mu.Unlock()
+		return nil, false
+	}
+	g.running = true
+	co := g.co
+	if g.started {
+		co.resume <- struct{}{}
+	} else {
+		g.started = true
+		go co.start()
+	}
+	g.mu.Unlock()
+
+	value, ok := <-co.values
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.running = false
+	if ok {
+		return value, true
+	}
+
+	g.done = true
+
+	// 本体がエラーで終わったら、それを最後の値にする
+	if err, isErr := co.result.(*Error); isErr {
+		return err, true
+	}
+
+	return nil, false
+}
+
+func (co *coroutine) start() {
+	defer close(co.values)
+
+	co.result = co.run(func(value Object) bool {
+		co.values <- value
+
+		select {
+		case <-co.resume:
+		case <-co.done:
+			return false
+		}
+
+		// 続きを求められても、done が閉じられていたら止める(両方そろったときに resume を選ぶことがある)
+		select {
+		case <-co.done:
+			return false
+		default:
+			return true
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// 評価に使える資源の上限
+// 0 の項目は無制限
+type Limits struct {
+	MaxSteps  int // 評価できるノードの数
+	MaxDepth  int // 関数呼び出しの深さ
+	MaxLength int // 1つの配列・ハッシュの要素数と、1つの文字列のバイト数
+	MaxAlloc  int // 評価中に作った値の大きさの合計(おおよそのバイト数)
+}
+
+// 資源の上限を超えたときの *Error の Err
+// errors.Is(errObj.Err, ErrResourceExhausted) で、ほかのエラーと区別できる
+var ErrResourceExhausted = errors.New("resource exhausted")
+
+// 上限と、これまでに使った量
+// 1回の評価(そこで呼び出した関数や spawn した関数の環境も含む)で1つを共有する
+// spawn した関数からも数えるので、使った量は atomic に読み書きする
+// (呼び出しの深さはゴルーチンごとに違うので、ここではなく環境ごとに持つ)
+type usage struct {
+	limits Limits
+	steps  int64
+	alloc  int64
+
+	// 評価を途中で止めるためのコンテキスト。nil なら止めない
+	mu  sync.RWMutex
+	ctx context.Context
+}
@@ -0,0 +1,86 @@
+package object
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// import で読み込まれたモジュール
+// モジュールの中で export された束縛だけを外に見せる
+type Module struct {
+	Path    string // モジュールのファイルの絶対パス
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string {
+	var names []string
+	for name := range m.Exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return "module(" + m.Path + ") { " + strings.Join(names, ", ") + " }"
+}
+
+// 読み込んだモジュールの登録簿
+// 同じモジュールは一度だけ評価して、以降はキャッシュしたものを返す
+// spawn した関数からも import できるように、ロックして読み書きする
+type ModuleRegistry struct {
+	// import のパスを探すディレクトリ(import する側のファイルのディレクトリの次に探す)
+	SearchPath []string
+
+	mu      sync.Mutex
+	loaded  map[string]*Module
+	loading map[string]chan struct{} // 読み込み中のモジュールのパスと、読み込み終えたら閉じるチャネル
+}
+
+func NewModuleRegistry() *ModuleRegistry {
+	return &ModuleRegistry{
+		loaded:  make(map[string]*Module),
+		loading: make(map[string]chan struct{}),
+	}
+}
+
+func (r *ModuleRegistry) Get(path string) (*Module, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.loaded[path]
+	return m, ok
+}
+
+// path の読み込みを始める
+//   - 読み込み済みなら、そのモジュールを返す
+//   - ほかのゴルーチンが読み込み中なら、読み込み終えたら閉じるチャネルを返す(閉じたらもう一度 Begin する)
+//   - どちらでもなければ読み込み中として記録して、両方 nil を返す(読み込み終えたら End を呼ぶ)
+func (r *ModuleRegistry) Begin(path string) (*Module, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.loaded[path]; ok {
+		return m, nil
+	}
+
+	if wait, ok := r.loading[path]; ok {
+		return nil, wait
+	}
+
+	r.loading[path] = make(chan struct{})
+	return nil, nil
+}
+
+// Begin で始めた path の読み込みを終える
+// m が nil なら読み込みに失敗したので登録しない(次に import したときにまた読み込む)
+func (r *ModuleRegistry) End(path string, m *Module) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m != nil {
+		r.loaded[path] = m
+	}
+
+	close(r.loading[path])
+	delete(r.loading, path)
+}
@@ -21,9 +21,18 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	MODULE_OBJ       = "MODULE"
+	TASK_OBJ         = "TASK"
+	CHANNEL_OBJ      = "CHANNEL"
+	WAIT_GROUP_OBJ   = "WAIT_GROUP"
+	ITERATOR_OBJ     = "ITERATOR"
+	GENERATOR_OBJ    = "GENERATOR"
 )
 
+// ハッシュのキーとして使えるオブジェクト
+// HashKey() が等しくても同じキーとは限らない(ハッシュ値の衝突)ので、object.Hash はキーの値も比べる
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -74,6 +83,9 @@ func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 type Error struct {
 	Message string
+
+	// Go 側のエラーが原因のとき、そのエラー(評価が取り消されたときの context.Canceled など)
+	Err error
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
@@ -83,6 +95,7 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Generator  bool // 呼び出すと本体を評価せずに Generator を返す
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -96,6 +109,9 @@ func (f *Function) Inspect() string {
 	}
 
 	out.WriteString("fn")
+	if f.Generator {
+		out.WriteString("*")
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
@@ -112,21 +128,69 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 func (s *String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Value: hashString(s.Value)}
+}
+
+func hashString(s string) uint64 {
 	h := fnv.New64a()
-	h.Write([]byte(s.Value))
+	h.Write([]byte(s))
 
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+	return h.Sum64()
 }
 
-type BuiltinFunction func(args ...Object) Object
+// env は組み込み関数を呼び出した環境
+type BuiltinFunction func(env *Environment, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
+
+	Name string
+	Doc  string // 一覧に表示する説明
+
+	// 引数の名前と型
+	// nil でなければ、Fn を呼ぶ前に引数の数と型を確かめる(nil なら Fn が自分で確かめる)
+	Params []BuiltinParam
+
+	// 呼び出すのに必要な権限。空なら権限はいらない
+	Requires Capability
+}
+
+// 組み込み関数の引数
+type BuiltinParam struct {
+	Name     string
+	Type     ObjectType // 空ならどんな型でも受け取る
+	Optional bool       // 省略できる(省略できる引数のあとに、省略できない引数は置けない)
+	Variadic bool       // いくつでも受け取る(最後の引数だけ)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+// 一覧に表示する呼び出し方
+// len(x) split(s: STRING, sep: STRING) sort(arr: ARRAY, cmp?) puts(args...)
+func (b *Builtin) Signature() string {
+	if b.Params == nil {
+		return b.Name + "(...)"
+	}
+
+	var params []string
+	for _, p := range b.Params {
+		param := p.Name
+		if p.Type != "" {
+			param += ": " + string(p.Type)
+		}
+		if p.Optional {
+			param += "?"
+		}
+		if p.Variadic {
+			param += "..."
+		}
+		params = append(params, param)
+	}
+
+	return b.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
 type Array struct {
 	Elements []Object
 }
@@ -158,24 +222,3 @@ type HashPair struct {
 	Key   Object
 	Value Object
 }
-
-type Hash struct {
-	Pairs map[HashKey]HashPair
-}
-
-func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
-
-	var pairs []string
-
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-
-	return out.String()
-}
@@ -2,6 +2,7 @@ package object
 
 import (
 	"testing"
+	"time"
 )
 
 // 簡単に比較可能で、object.Hashのハッシュキーとして使えるような、オブジェクトのハッシュ値を生成する方法
@@ -30,3 +31,428 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have save hash keys")
 	}
 }
+
+// ハッシュはキーを追加した順番を保つ
+func TestHashOrder(t *testing.T) {
+	key := func(s string) *String { return &String{Value: s} }
+	val := func(v int64) *Integer { return &Integer{Value: v} }
+
+	tests := []struct {
+		name     string
+		build    func(h *Hash)
+		expected string
+	}{
+		{
+			"追加した順",
+			func(h *Hash) {
+				h.Set(key("c"), val(1))
+				h.Set(key("a"), val(2))
+				h.Set(key("b"), val(3))
+			},
+			"{c: 1, a: 2, b: 3}",
+		},
+		{
+			"上書きしても順番は変わらない",
+			func(h *Hash) {
+				h.Set(key("a"), val(1))
+				h.Set(key("b"), val(2))
+				h.Set(key("a"), val(3))
+			},
+			"{a: 3, b: 2}",
+		},
+		{
+			"削除したあとに追加すると最後になる",
+			func(h *Hash) {
+				h.Set(key("a"), val(1))
+				h.Set(key("b"), val(2))
+				h.Delete(key("a"))
+				h.Set(key("a"), val(3))
+			},
+			"{b: 2, a: 3}",
+		},
+		{
+			"存在しないキーの削除",
+			func(h *Hash) {
+				h.Set(key("a"), val(1))
+				h.Delete(key("z"))
+			},
+			"{a: 1}",
+		},
+		{
+			"削除が続いて詰め直しても順番は変わらない",
+			func(h *Hash) {
+				for _, s := range []string{"a", "b", "c", "d", "e", "f"} {
+					h.Set(key(s), val(0))
+				}
+				for _, s := range []string{"a", "c", "d", "f"} {
+					h.Delete(key(s))
+				}
+				h.Set(key("b"), val(1))
+				h.Set(key("g"), val(2))
+			},
+			"{b: 1, e: 0, g: 2}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHash()
+			tt.build(h)
+
+			if h.Inspect() != tt.expected {
+				t.Errorf("wrong inspect. want=%q, got=%q", tt.expected, h.Inspect())
+			}
+
+			if h.Len() != len(h.Pairs()) {
+				t.Errorf("inconsistent length. Len()=%d, Pairs()=%d", h.Len(), len(h.Pairs()))
+			}
+
+			for _, expected := range h.Pairs() {
+				pair, ok := h.Get(key(expected.Key.Inspect()))
+				if !ok {
+					t.Fatalf("no pair for key %s", expected.Key.Inspect())
+				}
+
+				if pair != expected {
+					t.Errorf("Get() and Pairs() disagree for key %s", expected.Key.Inspect())
+				}
+			}
+
+			copied := h.Copy()
+			copied.Delete(h.Pairs()[0].Key.(Hashable))
+			if h.Inspect() != tt.expected {
+				t.Errorf("deleting from a copy changed the original. got=%q", h.Inspect())
+			}
+		})
+	}
+}
+
+// ハッシュ値が衝突しても、違うキーは別のキーとして扱う
+func TestHashCollision(t *testing.T) {
+	// すべてのキーが同じハッシュ値になるようにする
+	h := newHashWith(func(key Hashable) HashKey {
+		return HashKey{Type: STRING_OBJ, Value: 42}
+	})
+
+	a := &String{Value: "a"}
+	b := &String{Value: "b"}
+
+	h.Set(a, &Integer{Value: 1})
+	h.Set(b, &Integer{Value: 2})
+
+	if h.Len() != 2 {
+		t.Fatalf("colliding keys overwrote each other. got=%s", h.Inspect())
+	}
+
+	tests := []struct {
+		key      *String
+		expected int64
+	}{
+		{a, 1},
+		{b, 2},
+	}
+
+	for _, tt := range tests {
+		pair, ok := h.Get(&String{Value: tt.key.Value})
+		if !ok {
+			t.Fatalf("no pair for key %q", tt.key.Value)
+		}
+
+		if pair.Value.(*Integer).Value != tt.expected {
+			t.Errorf("wrong value for key %q. want=%d, got=%s", tt.key.Value, tt.expected, pair.Value.Inspect())
+		}
+	}
+
+	if _, ok := h.Get(&String{Value: "c"}); ok {
+		t.Errorf("found a pair for a key that was never set")
+	}
+
+	// コピーも同じ関数でハッシュ値を計算する
+	copied := h.Copy()
+	if copied.keyOf(a) != copied.keyOf(b) {
+		t.Errorf("copy does not use the same hash function")
+	}
+	if pair, ok := copied.Get(b); !ok || pair.Value.(*Integer).Value != 2 {
+		t.Errorf("wrong pair for a colliding key in the copy. got=%s", copied.Inspect())
+	}
+
+	h.Delete(&String{Value: "a"})
+	if _, ok := h.Get(b); !ok || h.Len() != 1 {
+		t.Errorf("deleting a colliding key removed the other. got=%s", h.Inspect())
+	}
+
+	if h.Inspect() != "{b: 2}" {
+		t.Errorf("wrong inspect. got=%q", h.Inspect())
+	}
+}
+
+// 等しい配列やハッシュは、同じハッシュ値にならないといけない
+func TestContainerHashKey(t *testing.T) {
+	hash := func(pairs ...Hashable) *Hash {
+		h := NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+	str := func(s string) *String { return &String{Value: s} }
+	num := func(n int64) *Integer { return &Integer{Value: n} }
+
+	tests := []struct {
+		name  string
+		a, b  Hashable
+		equal bool
+	}{
+		{"同じ要素の配列", &Array{Elements: []Object{num(1), str("a")}}, &Array{Elements: []Object{num(1), str("a")}}, true},
+		{"順番の違う配列", &Array{Elements: []Object{num(1), num(2)}}, &Array{Elements: []Object{num(2), num(1)}}, false},
+		{"入れ子の配列", &Array{Elements: []Object{&Array{Elements: []Object{num(1)}}}}, &Array{Elements: []Object{&Array{Elements: []Object{num(1)}}}}, true},
+		{"順番の違うハッシュ", hash(str("a"), num(1), str("b"), num(2)), hash(str("b"), num(2), str("a"), num(1)), true},
+		{"値の違うハッシュ", hash(str("a"), num(1)), hash(str("a"), num(2)), false},
+		{"空の配列と空のハッシュ", &Array{}, NewHash(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Equal(tt.a, tt.b) != tt.equal {
+				t.Fatalf("Equal(%s, %s) should be %t", tt.a.Inspect(), tt.b.Inspect(), tt.equal)
+			}
+
+			if tt.equal && tt.a.HashKey() != tt.b.HashKey() {
+				t.Errorf("equal objects have different hash keys: %s, %s", tt.a.Inspect(), tt.b.Inspect())
+			}
+		})
+	}
+}
+
+func TestAsHashable(t *testing.T) {
+	fn := &Builtin{}
+
+	tests := []struct {
+		name     string
+		obj      Object
+		hashable bool
+	}{
+		{"整数", &Integer{Value: 1}, true},
+		{"組み込み関数", fn, false},
+		{"整数の配列", &Array{Elements: []Object{&Integer{Value: 1}}}, true},
+		{"関数を含む配列", &Array{Elements: []Object{&Integer{Value: 1}, fn}}, false},
+		{"関数を含む配列を含む配列", &Array{Elements: []Object{&Array{Elements: []Object{fn}}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := AsHashable(tt.obj); ok != tt.hashable {
+				t.Errorf("AsHashable(%s) should be %t", tt.obj.Inspect(), tt.hashable)
+			}
+		})
+	}
+}
+
+func TestBuiltinSignature(t *testing.T) {
+	tests := []struct {
+		name     string
+		builtin  *Builtin
+		expected string
+	}{
+		{"引数の宣言なし", &Builtin{Name: "puts"}, "puts(...)"},
+		{"引数なし", &Builtin{Name: "now", Params: []BuiltinParam{}}, "now()"},
+		{
+			"型・省略・可変長",
+			&Builtin{Name: "f", Params: []BuiltinParam{
+				{Name: "s", Type: STRING_OBJ},
+				{Name: "n", Type: INTEGER_OBJ, Optional: true},
+				{Name: "rest", Variadic: true},
+			}},
+			"f(s: STRING, n: INTEGER?, rest...)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.builtin.Signature(); got != tt.expected {
+				t.Errorf("want=%q, got=%q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		err      string
+	}{
+		{"空", "", "", ""},
+		{"none", "none", "", ""},
+		{"1つ", "stdout", "stdout", ""},
+		{"カンマ区切り", "clock, random", "clock,random", ""},
+		{"all", "all", "clock,env,filesystem,modules,random,stdout", ""},
+		{"知らない権限", "stdout,network", "", `unknown capability "network"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps, err := ParseCapabilities(tt.input)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("want error %q, got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caps.String() != tt.expected {
+				t.Errorf("want=%q, got=%q", tt.expected, caps.String())
+			}
+		})
+	}
+
+	// 空の一覧は「何も許さない」で、設定なし(すべて許す)とは違う
+	none, _ := ParseCapabilities("")
+	if (&Settings{Capabilities: none}).Allows(CapStdout) {
+		t.Errorf("empty capabilities should allow nothing")
+	}
+	if !(&Settings{}).Allows(CapStdout) {
+		t.Errorf("nil capabilities should allow everything")
+	}
+}
+
+func TestWaitGroup(t *testing.T) {
+	wg := NewWaitGroup()
+
+	isClosed := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if !isClosed(wg.Wait()) {
+		t.Fatalf("a new wait group should not block")
+	}
+
+	wg.Add(2)
+	wait := wg.Wait()
+	if isClosed(wait) {
+		t.Fatalf("wait group with counter 2 should block")
+	}
+
+	wg.Add(-1)
+	if isClosed(wait) {
+		t.Fatalf("wait group with counter 1 should block")
+	}
+
+	wg.Add(-1)
+	if !isClosed(wait) {
+		t.Fatalf("wait group with counter 0 should not block")
+	}
+
+	if err := wg.Add(-1); err == nil || err.Error() != "negative wait group counter" {
+		t.Errorf("wrong error: %v", err)
+	}
+	if wg.Inspect() != "waitGroup(0)" {
+		t.Errorf("counter should not change on error: %s", wg.Inspect())
+	}
+}
+
+func TestRangeIterator(t *testing.T) {
+	tests := []struct {
+		name             string
+		start, end, step int64
+		expected         []int64
+	}{
+		{"昇順", 0, 3, 1, []int64{0, 1, 2}},
+		{"刻み", 1, 10, 4, []int64{1, 5, 9}},
+		{"降順", 3, 0, -2, []int64{3, 1}},
+		{"空", 5, 5, 1, nil},
+		{"逆向きの刻みは空", 0, 5, -1, nil},
+		{"最大値の手前まで", 9223372036854775806, 9223372036854775807, 1, []int64{9223372036854775806}},
+		{"最小値の手前まで", -9223372036854775807, -9223372036854775808, -10, []int64{-9223372036854775807}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := NewRangeIterator(tt.start, tt.end, tt.step)
+
+			var got []int64
+			for !it.Done() {
+				value, ok := it.Next()
+				if !ok {
+					t.Fatalf("Next() returned no value though Done() is false")
+				}
+				got = append(got, value.(*Integer).Value)
+			}
+
+			if len(got) != len(tt.expected) {
+				t.Fatalf("wrong values. want=%v, got=%v", tt.expected, got)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Fatalf("wrong values. want=%v, got=%v", tt.expected, got)
+				}
+			}
+
+			if _, ok := it.Next(); ok {
+				t.Errorf("Next() returned a value after the end")
+			}
+		})
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	g := NewGenerator(nil, func(yield func(Object) bool) Object {
+		yield(&Integer{Value: 1})
+		return &Error{Message: "boom"}
+	})
+
+	if value, ok := g.Next(); !ok || value.(*Integer).Value != 1 {
+		t.Fatalf("first value wrong. got=%v, %t", value, ok)
+	}
+
+	// 本体のエラーは最後の値として1回だけ返す
+	if value, ok := g.Next(); !ok || value.Inspect() != "ERROR: boom" {
+		t.Fatalf("error of the body was not returned. got=%v, %t", value, ok)
+	}
+
+	if value, ok := g.Next(); ok {
+		t.Errorf("generator should be exhausted after an error. got=%v", value)
+	}
+	if !g.Done() || g.Inspect() != "generator(done)" {
+		t.Errorf("generator should be done. got=%s", g.Inspect())
+	}
+}
+
+// done が閉じられたら、途中で止まっている本体を終わらせる
+func TestStoppedGenerator(t *testing.T) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	g := NewGenerator(done, func(yield func(Object) bool) Object {
+		defer close(stopped)
+		for i := int64(0); yield(&Integer{Value: i}); i++ {
+		}
+		return &Error{Message: "stopped"}
+	})
+	g.Next()
+
+	close(done)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("body of a stopped generator did not stop")
+	}
+
+	// 止まったあとは、本体の戻り値を最後の値として返して終わる
+	if value, ok := g.Next(); !ok || value.Inspect() != "ERROR: stopped" {
+		t.Errorf("result of the body was not returned. got=%v, %t", value, ok)
+	}
+	if !g.Done() {
+		t.Errorf("generator should be done. got=%s", g.Inspect())
+	}
+}
@@ -0,0 +1,60 @@
+package object
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// 評価の振る舞いを変える設定
+// 環境の木(関数呼び出しの環境や import したモジュールの環境も含む)全体で1つを共有する
+type Settings struct {
+	// 範囲外の添字や存在しないキーでの添字アクセスを、NULL ではなくエラーにする
+	StrictIndex bool
+
+	// readFile などのファイル操作の組み込み関数が扱えるディレクトリ
+	// この外(シンボリックリンクでたどった先も含む)にはアクセスできない。空ならファイル操作はできない
+	FileRoot string
+
+	// 組み込み関数と import に許す権限。nil ならすべて許す
+	// interp.New は空の集まりにして何も許さないので、組み込む側が必要なものだけを許す
+	Capabilities Capabilities
+
+	// puts や print が書き出す先と、eprint が書き出す先
+	// nil ならプロセスの標準出力と標準エラー出力
+	Stdout io.Writer
+	Stderr io.Writer
+
+	outputMu sync.Mutex
+}
+
+// str を w に書き出す
+// spawn した関数の出力が混ざらないように、同じ設定を使う評価のあいだでは1つずつ書き出す
+func (s *Settings) Output(w io.Writer, str string) error {
+	s.outputMu.Lock()
+	defer s.outputMu.Unlock()
+
+	_, err := io.WriteString(w, str)
+	return err
+}
+
+func (s *Settings) StdoutWriter() io.Writer {
+	if s.Stdout == nil {
+		return os.Stdout
+	}
+
+	return s.Stdout
+}
+
+func (s *Settings) StderrWriter() io.Writer {
+	if s.Stderr == nil {
+		return os.Stderr
+	}
+
+	return s.Stderr
+}
+
+// 権限 c を許しているか
+func (s *Settings) Allows(c Capability) bool {
+	return s.Capabilities == nil || s.Capabilities[c]
+}
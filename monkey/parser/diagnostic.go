@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"go-monkey-shakyo/monkey/token"
+	"strings"
+)
+
+// 診断の重大度
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// 診断の種類を表すエラーコード
+type DiagnosticCode string
+
+const (
+	UnexpectedToken   DiagnosticCode = "E0001" // 次のトークンが期待したものではない
+	MissingExpression DiagnosticCode = "E0002" // 式が始まるべき位置に式を始められないトークンがある
+	InvalidInteger    DiagnosticCode = "E0003" // 整数リテラルとして解釈できない
+	UnterminatedBlock DiagnosticCode = "E0004" // `}` で閉じられないまま入力が終わった
+)
+
+// ソースコード上の範囲 [Start, End)
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+// 構文解析器が報告する診断
+// Errors() が返していた文字列のメッセージに加えて、どこで何が起きたのかを構造化して持つ
+type Diagnostic struct {
+	Severity Severity
+	Code     DiagnosticCode
+	Message  string
+	Span     Span
+
+	Expected []token.TokenType // 期待していたトークン(わかる場合のみ)
+	Found    token.Token       // 実際に見つかったトークン
+
+	Hint string // 修正のヒント(なければ空)
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s[%s]: %s", d.Span.Start.Line, d.Span.Start.Column, d.Severity, d.Code, d.Message)
+}
+
+// 診断を、問題のある行とその下に引いた ^ の下線つきで整形する
+//
+//	1:5: error[E0001]: expected next token to be IDENT, got = instead
+//	    let = 5;
+//	        ^
+//	    hint: a name is expected here, e.g. `let x = 5;` or `fn(x) { x }`
+func (d Diagnostic) Render(source string) string {
+	var out bytes.Buffer
+
+	out.WriteString(d.String())
+	out.WriteString("\n")
+
+	lines := strings.Split(source, "\n")
+	if 1 <= d.Span.Start.Line && d.Span.Start.Line <= len(lines) {
+		line := strings.TrimRight(lines[d.Span.Start.Line-1], "\r")
+
+		out.WriteString("    " + line + "\n")
+		out.WriteString("    " + underline(line, d.Span) + "\n")
+	}
+
+	if d.Hint != "" {
+		out.WriteString("    hint: " + d.Hint + "\n")
+	}
+
+	return out.String()
+}
+
+// span の位置に ^ を並べる
+// タブがあっても位置がずれないように、行頭からの空白はソースの文字をそのまま使う
+func underline(line string, span Span) string {
+	start := span.Start.Column - 1
+	if start > len(line) {
+		start = len(line)
+	}
+
+	var indent bytes.Buffer
+	for _, ch := range []byte(line[:start]) {
+		if ch == '\t' {
+			indent.WriteByte('\t')
+		} else {
+			indent.WriteByte(' ')
+		}
+	}
+
+	width := 1
+	if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		width = span.End.Column - span.Start.Column
+	}
+
+	return indent.String() + strings.Repeat("^", width)
+}
+
+// トークンが占める範囲
+func tokenSpan(tok token.Token) Span {
+	length := len(tok.Literal)
+	if tok.Type == token.STRING {
+		// 両端の二重引用符の分
+		length += 2
+	}
+
+	end := tok.Pos
+	end.Offset += length
+	end.Column += length
+
+	return Span{Start: tok.Pos, End: end}
+}
+
+// 閉じ括弧のように、書き忘れたときに挿入すれば直るトークン
+var closingTokens = map[token.TokenType]bool{
+	token.RPAREN:   true,
+	token.RBRACE:   true,
+	token.RBRACEKT: true,
+}
+
+// expected が期待されていた場所で found が見つかったときの修正のヒント
+func unexpectedTokenHint(expected token.TokenType, found token.Token) string {
+	switch {
+	case closingTokens[expected] && found.Type == token.EOF:
+		return fmt.Sprintf("insert %q at the end of the input", string(expected))
+	case closingTokens[expected]:
+		return fmt.Sprintf("insert %q before %q", string(expected), found.Literal)
+	case expected == token.IDENT:
+		return "a name is expected here, e.g. `let x = 5;` or `fn(x) { x }`"
+	case expected == token.ASSIGN:
+		return "use `=` to bind a value, e.g. `let x = 5;`"
+	case expected == token.LET:
+		return "only `let` bindings can be exported, e.g. `export let x = 5;`"
+	case expected == token.STRING:
+		return "the module path must be a string, e.g. `import \"lib/math\"`"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"go-monkey-shakyo/monkey/lexer"
+	"go-monkey-shakyo/monkey/token"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedCode     DiagnosticCode
+		expectedLine     int
+		expectedColumn   int
+		expectedExpected []token.TokenType
+		expectedFound    token.TokenType
+	}{
+		{"let の後に識別子がない", "let = 5;", UnexpectedToken, 1, 5, []token.TokenType{token.IDENT}, token.ASSIGN},
+		{"閉じ括弧がない", "let x = 5;\n(1 + 2;", UnexpectedToken, 2, 7, []token.TokenType{token.RPAREN}, token.SEMICOLON},
+		{"式がない", "5 + ;", MissingExpression, 1, 5, nil, token.SEMICOLON},
+		{"整数が大きすぎる", "99999999999999999999", InvalidInteger, 1, 1, nil, token.INT},
+		{"ブロックが閉じられていない", "fn() {\n  1", UnterminatedBlock, 2, 4, []token.TokenType{token.RBRACE}, token.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) != 1 {
+				t.Fatalf("wrong number of diagnostics. want=1, got=%d (%v)", len(diagnostics), diagnostics)
+			}
+
+			d := diagnostics[0]
+			if d.Severity != SeverityError {
+				t.Errorf("d.Severity wrong. want=%s, got=%s", SeverityError, d.Severity)
+			}
+
+			if d.Code != tt.expectedCode {
+				t.Errorf("d.Code wrong. want=%s, got=%s", tt.expectedCode, d.Code)
+			}
+
+			if d.Span.Start.Line != tt.expectedLine || d.Span.Start.Column != tt.expectedColumn {
+				t.Errorf("d.Span.Start wrong. want=%d:%d, got=%d:%d",
+					tt.expectedLine, tt.expectedColumn, d.Span.Start.Line, d.Span.Start.Column)
+			}
+
+			if len(d.Expected) != len(tt.expectedExpected) {
+				t.Fatalf("d.Expected wrong. want=%v, got=%v", tt.expectedExpected, d.Expected)
+			}
+			for i, expected := range tt.expectedExpected {
+				if d.Expected[i] != expected {
+					t.Errorf("d.Expected[%d] wrong. want=%s, got=%s", i, expected, d.Expected[i])
+				}
+			}
+
+			if d.Found.Type != tt.expectedFound {
+				t.Errorf("d.Found.Type wrong. want=%s, got=%s", tt.expectedFound, d.Found.Type)
+			}
+		})
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	input := "let x = 1;\nlet y = \"ab\" + ;\n"
+
+	p := New(lexer.New(input))
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. want=1, got=%d", len(diagnostics))
+	}
+
+	expected := `2:16: error[E0002]: no prefix parse function for ; found
+    let y = "ab" + ;
+                   ^
+    hint: an expression is expected here
+`
+
+	if got := diagnostics[0].Render(input); got != expected {
+		t.Errorf("Render() wrong.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
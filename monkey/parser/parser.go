@@ -49,7 +49,11 @@ var precedences = map[token.TokenType]int{
 type Parser struct {
 	l *lexer.Lexer // パーサーは字句解析器を(のポインタ)もつ
 
-	errors []string
+	diagnostics []Diagnostic
+
+	// パニックモード: エラーを報告してから次の文の境界に同期するまでの間は true になる
+	// この間に起きたエラーは最初のエラーの巻き添えなので報告しない
+	panicMode bool
 
 	curToken  token.Token // 現在のトークンを指し示す(※文字じゃないよ！)
 	peekToken token.Token // 次のトークンを指し示す(※文字じゃないよ！)
@@ -57,6 +61,8 @@ type Parser struct {
 	// トークンタイプごとに適切な構文解析関数を持てるようにする
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	tracer *tracer // nil ならトレースしない
 }
 
 type (
@@ -70,10 +76,14 @@ type (
 	infixParseFn func(ast.Expression) ast.Expression
 )
 
-func New(l *lexer.Lexer) *Parser {
+func New(l *lexer.Lexer, opts ...Option) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []Diagnostic{},
+	}
+
+	for _, opt := range opts {
+		opt(p)
 	}
 
 	// 2つトークンを読み込む。
@@ -111,6 +121,9 @@ func New(l *lexer.Lexer) *Parser {
 	// p.217 ハッシュリテラル
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
+	// モジュールの読み込み
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
+
 	// 中置演算子の解析用関数の登録
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -132,13 +145,42 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// 構文解析中に見つかった診断
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+// 診断のメッセージだけを返す(互換性のため)
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+
+	for _, d := range p.diagnostics {
+		errors = append(errors, d.Message)
+	}
+
+	return errors
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(Diagnostic{
+		Code:     UnexpectedToken,
+		Message:  fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type),
+		Span:     tokenSpan(p.peekToken),
+		Expected: []token.TokenType{t},
+		Found:    p.peekToken,
+		Hint:     unexpectedTokenHint(t, p.peekToken),
+	})
+}
+
+// エラーを記録してパニックモードに入る
+func (p *Parser) addError(d Diagnostic) {
+	if p.panicMode {
+		return
+	}
+
+	d.Severity = SeverityError
+	p.diagnostics = append(p.diagnostics, d)
+	p.panicMode = true
 }
 
 func (p *Parser) nextToken() {
@@ -153,7 +195,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 	// EOFになるまで「トークン」を読み続ける
 	// 構文を解析しては、Statementとして溜め込んでいく
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
+		stmt, _ := p.parseStatementWithRecovery()
 
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
@@ -164,6 +206,67 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// 文を1つ解析し、構文エラーがあれば次の文の境界まで読み飛ばす(パニックモード回復)
+// 文そのものが組み立てられなかった場合は ast.BadStatement を返す
+// 2つ目の戻り値は、回復のために読み飛ばしを行ったかどうか
+func (p *Parser) parseStatementWithRecovery() (ast.Statement, bool) {
+	start := p.curToken
+
+	stmt := p.parseStatement()
+	if !p.panicMode {
+		return stmt, false
+	}
+
+	p.synchronize()
+	p.panicMode = false
+
+	if stmt == nil {
+		return &ast.BadStatement{Token: start}, true
+	}
+
+	return stmt, true
+}
+
+// 文の境界までトークンを読み飛ばす
+// curToken が `;` の位置か、peekToken が次の文の先頭や `}` や EOF になる位置で止まる
+// 止まった位置の次のトークンから解析を再開すればよい
+// 読み飛ばす途中で `{` に入ったら、対応する `}` までは境界とみなさない
+func (p *Parser) synchronize() {
+	// `}` の位置でエラーになったなら、その `}` は囲んでいるブロックの終わりとして扱う
+	if p.curTokenIs(token.RBRACE) {
+		return
+	}
+
+	depth := 0
+	if p.curTokenIs(token.LBRACE) {
+		depth = 1
+	}
+
+	for {
+		if depth == 0 && p.curTokenIs(token.SEMICOLON) {
+			return
+		}
+
+		switch p.peekToken.Type {
+		case token.EOF:
+			return
+		case token.LET, token.RETURN, token.EXPORT, token.YIELD:
+			if depth == 0 {
+				return
+			}
+		case token.LBRACE:
+			depth += 1
+		case token.RBRACE:
+			if depth == 0 {
+				return
+			}
+			depth -= 1
+		}
+
+		p.nextToken()
+	}
+}
+
 // プログラムが読んでいるトークンにあわせて構文を解析していく
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
@@ -171,6 +274,10 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.YIELD:
+		return p.parseYieldStatement()
 	default:
 		// Monkeyにおける純粋な文は2種類で、let文とreturn文しか存在しない。
 		// もしそれ以外のものが出現したら式文の構文解析を試みることにしよう
@@ -179,6 +286,8 @@ func (p *Parser) parseStatement() ast.Statement {
 }
 
 func (p *Parser) parseLetStatement() ast.Statement {
+	defer p.untrace(p.trace("parseLetStatement"))
+
 	// let文は
 	// 		let <identifier> = <expression>;
 	// という構造なので、 let → Identifier → ASSIGN → Expression → SEMICOLON と期待していく
@@ -214,7 +323,8 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	//           cur peek
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	// セミコロンは省略可能
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -243,6 +353,8 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) parseReturnStatement() ast.Statement {
+	defer p.untrace(p.trace("parseReturnStatement"))
+
 	// return文
 	// 	return <expression>;
 	// という構造なので、 RETURN → EXPRESSION → SEMICOLON と期待していく感じ
@@ -258,7 +370,7 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 	// ex: return a + b ;
 	//                | |
 	//             cur peek
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -280,6 +392,8 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) parseExpressionStatement() ast.Statement {
+	defer p.untrace(p.trace("parseExpressionStatement"))
+
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
 	stmt.Expression = p.parseExpression(LOWEST)
@@ -299,13 +413,21 @@ func (p *Parser) parseExpressionStatement() ast.Statement {
 
 // p.curToken.Typeの前置に関連付けられた構文解析関数を調べて、存在するなら呼び出す
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	defer p.untrace(p.trace("parseExpression(" + precedenceNames[precedence] + ")"))
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		return &ast.BadExpression{Token: p.curToken}
 	}
 
+	start := p.curToken
+
+	// 解析関数が式を組み立てられなかったときは、部分的なASTを保つためにエラーノードで置き換える
 	leftExp := prefix()
+	if leftExp == nil {
+		return &ast.BadExpression{Token: start}
+	}
 
 	// `precedence < p.peekPrecedence()` は"結合力"のチェックをしている
 	//  つまり、2つの演算子(op1, op2 とする)の優先度を比べて、i)かii)の判断をする感じ
@@ -332,6 +454,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		p.nextToken()
 
 		leftExp = infix(leftExp)
+		if leftExp == nil {
+			return &ast.BadExpression{Token: start}
+		}
 	}
 
 	return leftExp
@@ -342,13 +467,20 @@ func (p *Parser) parseIdentifier() ast.Expression {
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseIntegerLiteral"))
+
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	// 整数リテラルの文字列をint64に変換する
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(Diagnostic{
+			Code:    InvalidInteger,
+			Message: fmt.Sprintf("could not parse %q as integer", p.curToken.Literal),
+			Span:    tokenSpan(p.curToken),
+			Found:   p.curToken,
+			Hint:    "integer literals must fit in 64 bits",
+		})
 		return nil
 	}
 
@@ -358,11 +490,18 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(Diagnostic{
+		Code:    MissingExpression,
+		Message: fmt.Sprintf("no prefix parse function for %s found", t),
+		Span:    tokenSpan(p.curToken),
+		Found:   p.curToken,
+		Hint:    "an expression is expected here",
+	})
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
+	defer p.untrace(p.trace("parsePrefixExpression"))
+
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
@@ -393,6 +532,8 @@ func (p *Parser) curPrecedence() int {
 
 // left という Expression を受け取っているのが、 parsePrefixExpression との重要な違い
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseInfixExpression"))
+
 	expression := &ast.InfixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
@@ -412,6 +553,8 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	defer p.untrace(p.trace("parseGroupedExpression"))
+
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
@@ -424,6 +567,8 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 }
 
 func (p *Parser) parseIfExpression() ast.Expression {
+	defer p.untrace(p.trace("parseIfExpression"))
+
 	// if ( <condition> ) { <consequence> }
 	// if  →  (  →  式  →  )  →  {  →  式  →  }
 	expression := &ast.IfExpression{Token: p.curToken} // if式のASTノード作成
@@ -486,6 +631,8 @@ func (p *Parser) parseIfExpression() ast.Expression {
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	defer p.untrace(p.trace("parseBlockStatement"))
+
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
 
@@ -498,14 +645,31 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	//                    | |
 	//                  cur peek
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt, recovered := p.parseStatementWithRecovery()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 
+		// エラーが `}` の位置で起きた場合、その `}` でブロックを閉じる
+		if recovered && p.curTokenIs(token.RBRACE) {
+			break
+		}
+
 		p.nextToken()
 	}
 
+	// `}` が見つからないまま入力が終わった
+	if p.curTokenIs(token.EOF) {
+		p.addError(Diagnostic{
+			Code:     UnterminatedBlock,
+			Message:  fmt.Sprintf("expected next token to be %s, got %s instead", token.RBRACE, token.EOF),
+			Span:     tokenSpan(p.curToken),
+			Expected: []token.TokenType{token.RBRACE},
+			Found:    p.curToken,
+			Hint:     fmt.Sprintf("insert %q to close the block opened at %d:%d", string(token.RBRACE), block.Token.Pos.Line, block.Token.Pos.Column),
+		})
+	}
+
 	// ex: if ( x < y ) { x }
 	//                      | |
 	//                    cur peek
@@ -513,8 +677,19 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseFunctionLiteral"))
+
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
+	// fn* ならジェネレータ関数
+	// ex: fn * ( x ) { yield x; }
+	//      | |
+	//    cur peek
+	if p.peekTokenIs(token.ASTERISK) {
+		p.nextToken()
+		lit.Generator = true
+	}
+
 	// ex: fn ( x , y ) { x + y; }
 	//      | |
 	//    cur peek
@@ -555,7 +730,9 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	// ex: fn ( x , y ) { x + y; }
 	//        | |
 	//      cur peek
-	p.nextToken()
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
 
 	// ex: fn ( x , y ) { x + y; }
 	//          | |
@@ -572,7 +749,9 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		// ex: fn ( x , y ) { x + y; }
 		//            | |
 		//          cur peek
-		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 
 		// ex: fn ( x , y ) { x + y; }
 		//              | |
@@ -595,6 +774,8 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseCallExpression"))
+
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
@@ -608,6 +789,8 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseArrayLiteral"))
+
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACEKT)
@@ -664,10 +847,51 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 // myArray[0]における `[` を 中置演算子として扱い、
 // `myArray` を左のオペランド,  `0` を右のオペランドとして扱う
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	defer p.untrace(p.trace("parseIndexExpression"))
+
+	tok := p.curToken
 
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+
+	// a[:end] のように開始位置を省略したスライス
+	if p.curTokenIs(token.COLON) {
+		return p.parseSliceExpression(tok, left, nil)
+	}
+
+	index := p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(tok, left, index)
+	}
+
+	if !p.expectPeek(token.RBRACEKT) {
+		return nil
+	}
+
+	return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+}
+
+// 最初の `:` まで読んだところから、残りの a[start:end:step] を構文解析する
+// end と step は省略できる
+func (p *Parser) parseSliceExpression(tok token.Token, left, start ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseSliceExpression"))
+
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+
+	if !p.peekTokenIs(token.COLON) && !p.peekTokenIs(token.RBRACEKT) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+
+		if !p.peekTokenIs(token.RBRACEKT) {
+			p.nextToken()
+			exp.Step = p.parseExpression(LOWEST)
+		}
+	}
 
 	if !p.expectPeek(token.RBRACEKT) {
 		return nil
@@ -677,6 +901,8 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseHashLiteral"))
+
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
@@ -693,6 +919,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -705,3 +932,62 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 	return hash
 }
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	defer p.untrace(p.trace("parseImportExpression"))
+
+	exp := &ast.ImportExpression{Token: p.curToken}
+
+	// ex: import "lib/math"
+	//       |       |
+	//      cur     peek
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+
+	exp.Path = p.curToken.Literal
+
+	return exp
+}
+
+func (p *Parser) parseExportStatement() ast.Statement {
+	defer p.untrace(p.trace("parseExportStatement"))
+
+	stmt := &ast.ExportStatement{Token: p.curToken}
+
+	// export できるのは let 文だけ
+	// ex: export let x = 5;
+	//       |     |
+	//      cur   peek
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+
+	let, ok := p.parseLetStatement().(*ast.LetStatement)
+	if !ok {
+		return nil
+	}
+
+	stmt.Statement = let
+
+	return stmt
+}
+
+func (p *Parser) parseYieldStatement() ast.Statement {
+	defer p.untrace(p.trace("parseYieldStatement"))
+
+	// yield文は return文と同じく
+	// 	yield <expression>;
+	// という構造
+	stmt := &ast.YieldStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
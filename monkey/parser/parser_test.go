@@ -354,7 +354,7 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{
 			"",
 			"3 + 4; -5 * 5",
-			"(3 + 4)((-5) * 5)",
+			"(3 + 4);((-5) * 5)",
 		},
 		{
 			"",
@@ -582,7 +582,7 @@ func testBooleanLiteral(t *testing.T, exp ast.Expression, value bool) bool {
 	}
 
 	if bo.TokenLiteral() != fmt.Sprintf("%t", value) {
-		t.Errorf("bo.TokenLiteral not %t. got=%s", value, bo.Token)
+		t.Errorf("bo.TokenLiteral not %t. got=%s", value, bo.TokenLiteral())
 		return false
 	}
 
@@ -862,6 +862,55 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"開始と終了", "a[1:2]", "(a[1:2])"},
+		{"開始を省略", "a[:2]", "(a[:2])"},
+		{"終了を省略", "a[1:]", "(a[1:])"},
+		{"両方を省略", "a[:]", "(a[:])"},
+		{"間隔", "a[1:5:2]", "(a[1:5:2])"},
+		{"間隔だけ", "a[::-1]", "(a[::(-1)])"},
+		{"間隔の前の終了を省略", "a[1::2]", "(a[1::2])"},
+		{"位置に式", "a[i + 1:len(a)]", "(a[(i + 1):len(a)])"},
+		{"添字式と組み合わせる", "a[1:][0]", "((a[1:])[0])"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			if program.String() != tt.expected {
+				t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+			}
+		})
+	}
+}
+
+func TestParsingSliceExpressionNode(t *testing.T) {
+	l := lexer.New("a[1:2:3]")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	slice, ok := stmt.Expression.(*ast.SliceExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.SliceExpression. got=%T", stmt.Expression)
+	}
+
+	testIdentifier(t, slice.Left, "a")
+	testIntegerLiteral(t, slice.Start, 1)
+	testIntegerLiteral(t, slice.End, 2)
+	testIntegerLiteral(t, slice.Step, 3)
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -892,7 +941,7 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
 		}
 
-		expectedValue := expected[literal.String()]
+		expectedValue := expected[literal.Value]
 
 		testIntegerLiteral(t, value, expectedValue)
 	}
@@ -955,9 +1004,9 @@ func TestParsingHshLiteralsWithExpressions(t *testing.T) {
 			continue
 		}
 
-		testFunc, ok := tests[literal.String()]
+		testFunc, ok := tests[literal.Value]
 		if !ok {
-			t.Errorf("No test function for key %q found", literal.String())
+			t.Errorf("No test function for key %q found", literal.Value)
 			continue
 		}
 
@@ -965,3 +1014,279 @@ func TestParsingHshLiteralsWithExpressions(t *testing.T) {
 	}
 
 }
+
+// 構文エラーがあっても最後まで解析を続けて、すべてのエラーを1回で報告できること
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		name               string
+		input              string
+		expectedErrors     []string
+		expectedStatements []string
+	}{
+		{
+			"セミコロンのないlet文でも終了する",
+			"let x = 5",
+			[]string{},
+			[]string{"let x = 5;"},
+		},
+		{
+			"壊れた文を飛ばして次の文から再開する",
+			"let = 5; let y = 10; return y;",
+			[]string{"expected next token to be IDENT, got = instead"},
+			[]string{"<bad statement>", "let y = 10;", "return y;"},
+		},
+		{
+			"複数のエラーをまとめて報告する",
+			"let x 5; let = 10; let z = 15;",
+			[]string{
+				"expected next token to be =, got INT instead",
+				"expected next token to be IDENT, got = instead",
+			},
+			[]string{"<bad statement>", "<bad statement>", "let z = 15;"},
+		},
+		{
+			"式の途中のエラーはエラーノードとして残る",
+			"let x = 5 + ; let y = 1;",
+			[]string{"no prefix parse function for ; found"},
+			[]string{"let x = (5 + <bad expression>);", "let y = 1;"},
+		},
+		{
+			"ブロックの中のエラーはブロックの中で回復する",
+			"fn(x) { let = 1; x } ; 5",
+			[]string{"expected next token to be IDENT, got = instead"},
+			[]string{"fn(x) { <bad statement>x }", "5"},
+		},
+		{
+			"閉じ括弧の位置のエラーはブロックの終わりとして扱う",
+			"if (true) { let x = } 10",
+			[]string{"no prefix parse function for } found"},
+			[]string{"if (true) { let x = <bad expression>; }", "10"},
+		},
+		{
+			"閉じ括弧がないまま入力が終わる",
+			"fn(x) { x",
+			[]string{"expected next token to be }, got EOF instead"},
+			[]string{"fn(x) { x }"},
+		},
+		{
+			"余分な閉じ括弧",
+			"} let a = 1;",
+			[]string{"no prefix parse function for } found"},
+			[]string{"<bad expression>", "let a = 1;"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+
+			errors := p.Errors()
+			if len(errors) != len(tt.expectedErrors) {
+				t.Fatalf("wrong number of errors. want=%d, got=%d (%q)", len(tt.expectedErrors), len(errors), errors)
+			}
+			for i, msg := range tt.expectedErrors {
+				if errors[i] != msg {
+					t.Errorf("errors[%d] wrong. want=%q, got=%q", i, msg, errors[i])
+				}
+			}
+
+			if len(program.Statements) != len(tt.expectedStatements) {
+				t.Fatalf("wrong number of statements. want=%d, got=%d (%q)", len(tt.expectedStatements), len(program.Statements), program.String())
+			}
+			for i, expected := range tt.expectedStatements {
+				if got := program.Statements[i].String(); got != expected {
+					t.Errorf("program.Statements[%d] wrong. want=%q, got=%q", i, expected, got)
+				}
+			}
+		})
+	}
+}
+
+// String() で書き出したソースを解析し直すと、同じ String() が得られること
+func TestProgramStringRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"式文が続くときはセミコロンで区切る", "a; (b)", "a;b"},
+		{"文字列リテラルは引用符つき", `let s = "foo bar";`, `let s = "foo bar";`},
+		{"if式", "if (x < y) { x } else { y }", "if ((x < y)) { x } else { y }"},
+		{"関数リテラル", "fn(x, y) { let z = x + y; z }", "fn(x, y) { let z = (x + y);z }"},
+		{"関数リテラルの呼び出し", "fn(x) { x }(1)", "fn(x) { x }(1)"},
+		{"ハッシュリテラルはキーの順番を保つ", `{"b": 2, "a": 1, 3: true}`, `{"b":2, "a":1, 3:true}`},
+		{"配列と添字", "[1, 2][0]", "([1, 2][0])"},
+		{"ジェネレータ関数とyield文", "fn*(n) { yield n * 2; }", "fn*(n) { yield (n * 2); }"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			if program.String() != tt.expected {
+				t.Fatalf("program.String() wrong. want=%q, got=%q", tt.expected, program.String())
+			}
+
+			p = New(lexer.New(program.String()))
+			reparsed := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			if reparsed.String() != tt.expected {
+				t.Errorf("reparsed.String() wrong. want=%q, got=%q", tt.expected, reparsed.String())
+			}
+		})
+	}
+}
+
+// 任意の入力に対して、構文解析器がパニックせずに終了すること
+// エラーがなければ、String() で書き出したものを解析し直しても同じ String() になること
+func FuzzParseProgram(f *testing.F) {
+	// 既存のテストの入力を種にする
+	seeds := []string{
+		"let x = 5;", "let y = true;", "let foobar = y;", "return 5;", "return foobar;",
+		"foobar;", "5;", "!5;", "-15;", "5 != 5;", "true == true",
+		"a + b * c + d / e - f", "3 + 4; -5 * 5", "3 + 4 * 5 == 3 * 1 + 4 * 5",
+		"1 + (2 + 3) + 4", "!(true == true)", "a + add(b * c) + d",
+		"add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))", "a * [1, 2, 3, 4][b * c] * d",
+		"if (x < y) { x }", "if (x < y) { x } else { y }", "fn(x, y) { x + y; }",
+		"fn() {};", "fn(x, y, z) {};", "add(1, 2 * 3, 4 + 5);", `"hello world";`,
+		"[1, 2 * 2, 3 + 3]", "myArray[1 + 1]", `{"one": 1, "two": 2, "three": 3}`, "{}",
+		`{"one": 0 + 1, "two": 10 - 8, "three": 15 / 5}`,
+		"let = 5; let y = 10;", "fn(x) { let = 1; x } ; 5", "if (true) { let x = } 10", "} let a = 1;",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+
+		// エラーがあっても部分的なASTは書き出せる
+		printed := program.String()
+
+		if len(p.Diagnostics()) != 0 {
+			return
+		}
+
+		p = New(lexer.New(printed))
+		reparsed := p.ParseProgram()
+
+		if len(p.Diagnostics()) != 0 {
+			t.Fatalf("String() output does not parse. input=%q, printed=%q, errors=%q", input, printed, p.Errors())
+		}
+
+		if reparsed.String() != printed {
+			t.Fatalf("String() is not stable. input=%q, printed=%q, reparsed=%q", input, printed, reparsed.String())
+		}
+	})
+}
+
+func TestImportExpression(t *testing.T) {
+	input := `let math = import "lib/math";`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+	}
+
+	stmt := program.Statements[0]
+	if !testLetStatement(t, stmt, "math") {
+		return
+	}
+
+	imp, ok := stmt.(*ast.LetStatement).Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.ImportExpression. got=%T", stmt.(*ast.LetStatement).Value)
+	}
+
+	if imp.Path != "lib/math" {
+		t.Errorf("imp.Path is not %q. got=%q", "lib/math", imp.Path)
+	}
+}
+
+func TestExportStatement(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedError string
+	}{
+		{"let文をexportする", "export let add = fn(x, y) { x + y };", ""},
+		{"let文以外はexportできない", "export 5;", "expected next token to be LET, got INT instead"},
+		{"importするパスは文字列", "import math;", "expected next token to be STRING, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			program := p.ParseProgram()
+
+			if tt.expectedError != "" {
+				errors := p.Errors()
+				if len(errors) != 1 || errors[0] != tt.expectedError {
+					t.Fatalf("wrong errors. want=%q, got=%q", tt.expectedError, errors)
+				}
+				return
+			}
+
+			checkParserErrors(t, p)
+
+			export, ok := program.Statements[0].(*ast.ExportStatement)
+			if !ok {
+				t.Fatalf("program.Statements[0] is not ast.ExportStatement. got=%T", program.Statements[0])
+			}
+
+			if !testLetStatement(t, export.Statement, "add") {
+				return
+			}
+
+			if export.String() != "export let add = fn(x, y) { (x + y) };" {
+				t.Errorf("export.String() wrong. got=%q", export.String())
+			}
+		})
+	}
+}
+
+func TestGeneratorParsing(t *testing.T) {
+	tests := []struct {
+		name              string
+		input             string
+		expectedGenerator bool
+	}{
+		{"fn* はジェネレータ関数", "fn*(x) { yield x; }", true},
+		{"fn と * の間は空けてもよい", "fn * (x) { yield x; }", true},
+		{"fn はふつうの関数", "fn(x) { yield x; }", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			stmt := program.Statements[0].(*ast.ExpressionStatement)
+			function, ok := stmt.Expression.(*ast.FunctionLiteral)
+			if !ok {
+				t.Fatalf("stmt.Expression is not ast.FunctionLiteral. got=%T", stmt.Expression)
+			}
+
+			if function.Generator != tt.expectedGenerator {
+				t.Errorf("function.Generator wrong. want=%t, got=%t", tt.expectedGenerator, function.Generator)
+			}
+
+			yield, ok := function.Body.Statements[0].(*ast.YieldStatement)
+			if !ok {
+				t.Fatalf("function body stmt is not ast.YieldStatement. got=%T", function.Body.Statements[0])
+			}
+
+			testLiteralExpression(t, yield.Value, "x")
+		})
+	}
+}
@@ -2,31 +2,75 @@ package parser
 
 import (
 	"fmt"
+	"io"
 	"strings"
 )
 
-var traceLevel = 0
-
 const traceIdentPlaceholder string = "\t"
 
-func identLevel() string {
-	return strings.Repeat(traceIdentPlaceholder, traceLevel-1)
+// 構文解析関数の呼び出しを BEGIN/END の入れ子として書き出す
+// 構文解析器ごとに持つので、複数の構文解析器を同時に動かしても混ざらない
+type tracer struct {
+	out   io.Writer
+	level int
+}
+
+func (t *tracer) identLevel() string {
+	return strings.Repeat(traceIdentPlaceholder, t.level-1)
 }
 
-func tracePrint(fs string) {
-	fmt.Printf("%s%s\n", identLevel(), fs)
+func (t *tracer) print(fs string) {
+	fmt.Fprintf(t.out, "%s%s\n", t.identLevel(), fs)
+}
+
+func (t *tracer) incIdent() { t.level = t.level + 1 }
+func (t *tracer) decIdent() { t.level = t.level - 1 }
+
+// 構文解析器のオプション
+type Option func(*Parser)
+
+// 構文解析の過程を w に書き出す
+// 演算子の優先順位がどう効いているかを確かめたいときに使う
+func WithTracer(w io.Writer) Option {
+	return func(p *Parser) {
+		p.tracer = &tracer{out: w}
+	}
 }
 
-func incIdent() { traceLevel = traceLevel + 1 }
-func decIdent() { traceLevel = traceLevel - 1 }
+// 使い方: defer p.untrace(p.trace("parseExpression"))
+func (p *Parser) trace(msg string) string {
+	if p.tracer == nil {
+		return msg
+	}
 
-func trace(msg string) string {
-	incIdent()
-	tracePrint("BEGIN " + msg)
+	p.tracer.incIdent()
+	p.tracer.print("BEGIN " + msg + " " + p.traceTokenInfo())
 	return msg
 }
 
-func untrace(msg string) {
-	tracePrint("END " + msg)
-	decIdent()
+func (p *Parser) untrace(msg string) {
+	if p.tracer == nil {
+		return
+	}
+
+	p.tracer.print("END " + msg + " " + p.traceTokenInfo())
+	p.tracer.decIdent()
+}
+
+// 現在のトークンとその位置
+func (p *Parser) traceTokenInfo() string {
+	pos := p.curToken.Pos
+	return fmt.Sprintf("[%d:%d %s %q]", pos.Line, pos.Column, p.curToken.Type, p.curToken.Literal)
+}
+
+// 優先順位の名前(トレース表示用)
+var precedenceNames = map[int]string{
+	LOWEST:      "LOWEST",
+	EQUALS:      "EQUALS",
+	LESSGREATER: "LESSGREATER",
+	SUM:         "SUM",
+	PRODUCT:     "PRODUCT",
+	PREFIX:      "PREFIX",
+	CALL:        "CALL",
+	INDEX:       "INDEX",
 }
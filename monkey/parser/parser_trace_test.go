@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"bytes"
+	"go-monkey-shakyo/monkey/lexer"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	var out bytes.Buffer
+
+	p := New(lexer.New("1 * 2"), WithTracer(&out))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := `BEGIN parseExpressionStatement [1:1 INT "1"]
+	BEGIN parseExpression(LOWEST) [1:1 INT "1"]
+		BEGIN parseIntegerLiteral [1:1 INT "1"]
+		END parseIntegerLiteral [1:1 INT "1"]
+		BEGIN parseInfixExpression [1:3 * "*"]
+			BEGIN parseExpression(PRODUCT) [1:5 INT "2"]
+				BEGIN parseIntegerLiteral [1:5 INT "2"]
+				END parseIntegerLiteral [1:5 INT "2"]
+			END parseExpression(PRODUCT) [1:5 INT "2"]
+		END parseInfixExpression [1:5 INT "2"]
+	END parseExpression(LOWEST) [1:5 INT "2"]
+END parseExpressionStatement [1:5 INT "2"]
+`
+
+	if out.String() != expected {
+		t.Errorf("trace wrong.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+// トレースは構文解析器ごとに独立していて、インデントが混ざらない
+func TestTracerIsPerParser(t *testing.T) {
+	var out1, out2 bytes.Buffer
+
+	p1 := New(lexer.New("-1"), WithTracer(&out1))
+	p2 := New(lexer.New("-1"), WithTracer(&out2))
+
+	// p1 の途中で p2 を動かしても、それぞれのトレースは同じになる
+	p1.trace("outer")
+	p2.ParseProgram()
+	p1.untrace("outer")
+	p1.ParseProgram()
+
+	expected := `BEGIN parseExpressionStatement [1:1 - "-"]
+	BEGIN parseExpression(LOWEST) [1:1 - "-"]
+		BEGIN parsePrefixExpression [1:1 - "-"]
+			BEGIN parseExpression(PREFIX) [1:2 INT "1"]
+				BEGIN parseIntegerLiteral [1:2 INT "1"]
+				END parseIntegerLiteral [1:2 INT "1"]
+			END parseExpression(PREFIX) [1:2 INT "1"]
+		END parsePrefixExpression [1:2 INT "1"]
+	END parseExpression(LOWEST) [1:2 INT "1"]
+END parseExpressionStatement [1:2 INT "1"]
+`
+
+	if out2.String() != expected {
+		t.Errorf("p2 trace wrong.\nwant=\n%s\ngot=\n%s", expected, out2.String())
+	}
+
+	if out1.String() != "BEGIN outer [1:1 - \"-\"]\nEND outer [1:1 - \"-\"]\n"+expected {
+		t.Errorf("p1 trace wrong. got=\n%s", out1.String())
+	}
+}
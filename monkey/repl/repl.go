@@ -2,12 +2,15 @@ package repl
 
 import (
 	"bufio"
-	"fmt"
+	"context"
+	"go-monkey-shakyo/monkey/ast"
 	"go-monkey-shakyo/monkey/evaluator"
 	"go-monkey-shakyo/monkey/lexer"
 	"go-monkey-shakyo/monkey/object"
 	"go-monkey-shakyo/monkey/parser"
 	"io"
+	"os"
+	"os/signal"
 )
 
 const MONKEY_FACE = `            __,__
@@ -25,12 +28,25 @@ const MONKEY_FACE = `            __,__
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// 使える組み込み関数の一覧を表示するコマンド
+const BUILTINS_COMMAND = ":builtins"
+
+// opts は入力を解析する構文解析器に渡される
+// プロンプトも評価結果も puts の出力も out に書き出す
+func Start(in io.Reader, out io.Writer, opts ...parser.Option) {
 	env := object.NewEnvironment()
+	env.Settings().Stdout = out
+
+	Run(in, out, env, opts...)
+}
+
+// Start と同じだが、設定を済ませた env で評価する
+// puts などの出力先は env の設定にしたがう(設定がなければプロセスの標準出力)
+func Run(in io.Reader, out io.Writer, env *object.Environment, opts ...parser.Option) {
+	scanner := bufio.NewScanner(in)
 
 	for {
-		fmt.Printf(PROMPT)
+		io.WriteString(out, PROMPT)
 
 		scanned := scanner.Scan()
 		if !scanned {
@@ -38,16 +54,21 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		line := scanner.Text()
+		if line == BUILTINS_COMMAND {
+			PrintBuiltins(out, env)
+			continue
+		}
+
 		l := lexer.New(line)
-		p := parser.New(l)
+		p := parser.New(l, opts...)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if len(p.Diagnostics()) != 0 {
+			PrintParserErrors(out, line, p.Diagnostics())
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		evaluated := evalLine(program, env)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -55,11 +76,35 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+// 1行ぶんのプログラムを、その行だけの評価として評価する
+// Ctrl-C (SIGINT) を受け取ったらその行の評価を止めて、REPL は次の入力を待つ
+func evalLine(program *ast.Program, env *object.Environment) object.Object {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return evaluator.Eval(program, env.NewEvaluation(ctx))
+}
+
+// 構文解析器の診断を、問題のあるソースの行と下線つきで出力する
+func PrintParserErrors(out io.Writer, source string, diagnostics []parser.Diagnostic) {
 	io.WriteString(out, MONKEY_FACE)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, d := range diagnostics {
+		io.WriteString(out, d.Render(source))
+	}
+}
+
+// 環境で使える組み込み関数を、名前順に呼び出し方と説明つきで出力する
+func PrintBuiltins(out io.Writer, env *object.Environment) {
+	for _, b := range evaluator.Builtins(env) {
+		io.WriteString(out, b.Signature())
+		if b.Requires != "" {
+			io.WriteString(out, " [requires "+string(b.Requires)+"]")
+		}
+		if b.Doc != "" {
+			io.WriteString(out, "\n    "+b.Doc)
+		}
+		io.WriteString(out, "\n")
 	}
 }
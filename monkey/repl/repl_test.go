@@ -0,0 +1,102 @@
+package repl
+
+import (
+	"go-monkey-shakyo/monkey/object"
+	"os"
+	"os/signal"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"評価結果", "1 + 2\n", ">> 3\n>> "},
+		{"束縛は行をまたいで残る", "let x = 5;\nx * 2\n", ">> >> 10\n>> "},
+		{"puts の出力も out に書く", "puts(\"hi\")\n", ">> hi\nnull\n>> "},
+		{"print は改行しない", "print(1, 2)\n", ">> 12null\n>> "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			Start(strings.NewReader(tt.input), &out)
+
+			if out.String() != tt.expected {
+				t.Errorf("want=%q, got=%q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestRunUsesEnvWriters(t *testing.T) {
+	var out, stdout, stderr strings.Builder
+
+	env := object.NewEnvironment()
+	env.Settings().Stdout = &stdout
+	env.Settings().Stderr = &stderr
+
+	Run(strings.NewReader("puts(1); eprint(2)\n"), &out, env)
+
+	if out.String() != ">> null\n>> " {
+		t.Errorf("wrong REPL output: %q", out.String())
+	}
+	if stdout.String() != "1\n" || stderr.String() != "2\n" {
+		t.Errorf("wrong script output: stdout=%q, stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestBuiltinsCommand(t *testing.T) {
+	var out strings.Builder
+	Start(strings.NewReader(BUILTINS_COMMAND+"\n"), &out)
+
+	for _, want := range []string{
+		"len(x)\n    length of an array, a string or a hash\n",
+		"random(n: INTEGER) [requires random]\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%q is not listed in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestInterruptStopsLine(t *testing.T) {
+	// 評価が始まる前に届いても落ちないように、テストの間は SIGINT を受け取っておく
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	proc, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+
+	var out strings.Builder
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(strings.NewReader("recv(channel())\n"), &out, object.NewEnvironment())
+	}()
+
+	// 受信で止まった行に届くまで、SIGINT を送り続ける
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case <-done:
+			if !strings.Contains(out.String(), "evaluation interrupted") {
+				t.Errorf("line was not interrupted: %q", out.String())
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+			if err := proc.Signal(os.Interrupt); err != nil {
+				t.Skip(err)
+			}
+		case <-deadline:
+			t.Fatal("REPL did not stop on SIGINT")
+		}
+	}
+}
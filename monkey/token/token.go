@@ -5,6 +5,14 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // トークンの先頭の位置
+}
+
+// ソースコード上の位置
+type Position struct {
+	Offset int // 入力の先頭からのバイトオフセット(0始まり)
+	Line   int // 行番号(1始まり)
+	Column int // 行頭からのバイト単位の列番号(1始まり)
 }
 
 const (
@@ -50,6 +58,9 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
+	YIELD    = "YIELD"
 
 	STRING = "STRING"
 )
@@ -62,6 +73,9 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"import": IMPORT,
+	"export": EXPORT,
+	"yield":  YIELD,
 }
 
 // ユーザ定義の識別子と言語のキーワードを区別する